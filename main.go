@@ -2,154 +2,211 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
-	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	"finalproject/stresstest/stress"
 	"k8s.io/klog"
-	"k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
-func main() {
-	// Initialize Kubernetes client using kubeconfig
-	kubeconfigPath := filepath.Join(homedir.HomeDir(), ".kube", "config")
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	if err != nil {
-		klog.Fatalf("Error building kubeconfig: %v", err)
-	}
+// exitDeadlineExceeded is the exit code used when -max-runtime stops a run
+// before every target was measured.
+const exitDeadlineExceeded = 3
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		klog.Fatalf("Error creating clientset: %v", err)
-	}
+// exitInterrupted is the exit code used when a signal stops a run early.
+const exitInterrupted = 130
 
-	// Initialize Metrics client
-	metricsClient, err := versioned.NewForConfig(config)
-	if err != nil {
-		klog.Fatalf("Error creating metrics clientset: %v", err)
-	}
+// exitDiagnoseFailed is the exit code used when a -diagnose check fails.
+const exitDiagnoseFailed = 1
 
-	// Read pod and namespace names from CSV file
-	podsFile, err := os.Open("pods.csv")
-	if err != nil {
-		klog.Fatalf("Error opening pods file: %v", err)
-	}
-	defer podsFile.Close()
+// exitFailOn is the exit code used when rows matched -fail-on.
+const exitFailOn = 4
 
-	podsCSV := csv.NewReader(podsFile)
-	podsCSV.FieldsPerRecord = -1 // Allow variable number of fields
-	podsData, err := podsCSV.ReadAll()
-	if err != nil {
-		klog.Fatalf("Error reading pods CSV: %v", err)
-	}
+// stringsFlag is a flag.Value that appends each use of a repeatable flag.
+type stringsFlag struct {
+	values *[]string
+}
 
-	// Create a CSV file to export metrics
-	metricsFile, err := os.Create("metrics.csv")
-	if err != nil {
-		klog.Fatalf("Error creating metrics CSV file: %v", err)
+func (f stringsFlag) String() string {
+	if f.values == nil {
+		return ""
 	}
-	defer metricsFile.Close()
-
-	metricsWriter := csv.NewWriter(metricsFile)
-	defer metricsWriter.Flush()
-
-	// Iterate over pods and stress test each
-	for _, podData := range podsData {
-		podName := strings.TrimSpace(podData[0])
-		namespace := strings.TrimSpace(podData[1])
-
-		klog.Infof("Stressing pod: %s in namespace: %s", podName, namespace)
-
-		var cpuTotalMilli, memoryTotal int64
-		var numContainers int
+	return strings.Join(*f.values, " ")
+}
 
-		// Get the pod from Kubernetes
-		pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
-		if err != nil {
-			klog.Errorf("Error getting pod: %v", err)
-			continue
-		}
+func (f stringsFlag) Set(value string) error {
+	*f.values = append(*f.values, value)
+	return nil
+}
 
-		// Extract the deployment name from the pod's metadata name
-		deploymentName := pod.ObjectMeta.Name
-		if deploymentName == "" {
-			klog.Warningf("No deployment found for pod: %s in namespace: %s", podName, namespace)
-			continue
-		}
+func main() {
+	defer klog.Flush()
+
+	cfg := stress.DefaultConfig()
+	flag.StringVar(&cfg.Input, "input", cfg.Input, "pods to stress: a pod,namespace CSV file or a kubectl PodList .json/.yaml manifest, optionally gzipped as .gz")
+	flag.StringVar(&cfg.InputComment, "input-comment", cfg.InputComment, "skip input CSV lines starting with this character, e.g. # (default none)")
+	flag.BoolVar(&cfg.Strict, "strict", cfg.Strict, "require the input CSV to start with a header of known columns (pod,namespace[,container], or namespace,deployment) and fail on any other; without it, there is no header and extra columns are ignored")
+	flag.StringVar(&cfg.InputConfigMap, "input-configmap", cfg.InputConfigMap, "read the pod,namespace CSV from a ConfigMap key, given as namespace/name/key, instead of -input")
+	flag.StringVar(&cfg.Namespace, "namespace", cfg.Namespace, "list the pods (or deployments) of this namespace instead of reading -input; with -all-namespaces, the fallback if listing cluster-wide is forbidden")
+	flag.BoolVar(&cfg.AllNamespaces, "all-namespaces", cfg.AllNamespaces, "list the pods (or deployments) of every namespace instead of reading -input")
+	flag.Var(stringsFlag{&cfg.Selectors}, "selector", "label `selector` for -namespace and -all-namespaces listing, e.g. app=web; repeat to union several, adding a selector column")
+	flag.Float64Var(&cfg.SampleRate, "sample-rate", cfg.SampleRate, "measure each listed target with this probability, for cheap estimates on large clusters (0-1]")
+	flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed for -sample-rate, to measure the same targets again (0 = time-based, logged)")
+	flag.IntVar(&cfg.MaxPodsPerNamespace, "max-pods-per-namespace", cfg.MaxPodsPerNamespace, "fail before measuring if any namespace has more targets than this, e.g. from an overly broad -selector (0 = no limit)")
+	flag.BoolVar(&cfg.TruncatePerNamespace, "truncate-per-namespace", cfg.TruncatePerNamespace, "keep the first -max-pods-per-namespace targets of each namespace with a warning instead of failing")
+	flag.BoolVar(&cfg.DiscoverNamespace, "discover-namespace", cfg.DiscoverNamespace, "for -input rows with only a pod name, find its namespace by listing pods cluster-wide, skipping names that are missing and failing on names found in several namespaces")
+	flag.StringVar(&cfg.Output, "output", cfg.Output, "file to write averaged metrics to")
+	flag.StringVar(&cfg.PeakOutput, "peak-output", cfg.PeakOutput, "also write each row's peak (max sample) metrics to this file, from the same samples as -output")
+	flag.BoolVar(&cfg.AppendSummary, "append-summary", cfg.AppendSummary, "end the CSV output with a row labeled TOTAL in its first column, summing cpu, memory and cpu_milli over the rows written, other columns empty")
+	flag.BoolVar(&cfg.NoClobber, "no-clobber", cfg.NoClobber, "refuse to start if the -output file, or a -format or -peak-output file, already exists")
+	flag.BoolVar(&cfg.SplitByNamespace, "split-by-namespace", cfg.SplitByNamespace, "with -output-dir, write each namespace's rows to its own <namespace>.csv, or other -format extension, with its own header, instead of one metrics file")
+	flag.StringVar(&cfg.OutputDir, "output-dir", cfg.OutputDir, "directory to write timestamped metrics-<RFC3339>.csv files to (mutually exclusive with -output)")
+	flag.BoolVar(&cfg.OnlyWithMetrics, "only-with-metrics", cfg.OnlyWithMetrics, "skip pods the metrics API has no metrics for, using one list per namespace")
+	flag.StringVar(&cfg.SampleStrategy, "sample-strategy", cfg.SampleStrategy, "how samples collapse to the reported CPU and memory: mean, median, max or last")
+	flag.BoolVar(&cfg.Weighted, "weighted", cfg.Weighted, "weight each sample by the freshness of its metrics timestamp instead of averaging equally")
+	flag.BoolVar(&cfg.Deployments, "deployments", cfg.Deployments, "treat input rows as namespace,deployment and aggregate each deployment's pods into one row")
+	flag.StringVar(&cfg.GroupByLabel, "group-by-label", cfg.GroupByLabel, "aggregate the rows into one per value of this pod label, e.g. team, with unlabeled pods under <none>")
+	flag.DurationVar(&cfg.MaxRuntime, "max-runtime", cfg.MaxRuntime, "abort the run after this wall-clock time, keeping partial results (0 = no limit)")
+	flag.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "number of targets to stress in parallel; output stays in input order")
+	flag.IntVar(&cfg.OrderBuffer, "order-buffer", cfg.OrderBuffer, "maximum targets in flight or awaiting ordered output (default 4x -concurrency)")
+	flag.IntVar(&cfg.ParallelNamespaces, "parallel-namespaces", cfg.ParallelNamespaces, "measure this many namespaces at once, each with -concurrency workers and its rows written together; with -all-namespaces, also list them in parallel (0 = off)")
+	flag.StringVar(&cfg.Mode, "mode", cfg.Mode, "metrics to sample without load, stress to apply the -stress load for -stress-duration without recording, e.g. to pre-warm pods, or both to sample under load")
+	flag.StringVar(&cfg.Stress, "stress", cfg.Stress, "exec a cpu or memory stress command in each pod while it is sampled, using only sh and timeout; needs -mode stress or both")
+	flag.StringVar(&cfg.StressCommand, "stress-command", cfg.StressCommand, "shell command template to exec instead of the -stress default (implies -stress cpu), or @file; e.g. 'timeout {{.DurationSeconds}} stress-ng --vm 1 --vm-bytes {{.MemMB}}M'. It should exit on its own")
+	flag.DurationVar(&cfg.StressDuration, "stress-duration", cfg.StressDuration, "how long the stress command runs, at least 1s, as {{.DurationSeconds}}")
+	flag.IntVar(&cfg.StressMemMB, "stress-mem-mb", cfg.StressMemMB, "memory the memory stress command allocates, as {{.MemMB}}")
+	flag.StringVar(&cfg.StressMinDelta, "stress-min-delta", cfg.StressMinDelta, "verify the stress command raised the container's CPU (e.g. 200m), or memory with -stress memory (e.g. 64Mi), by this much after -stress-verify-delay, adding stress_check columns")
+	flag.DurationVar(&cfg.StressVerifyDelay, "stress-verify-delay", cfg.StressVerifyDelay, "wait between starting the stress command and the -stress-min-delta reading; metrics-server refreshes about every 15s")
+	flag.BoolVar(&cfg.StressVerifyAbort, "stress-verify-abort", cfg.StressVerifyAbort, "skip pods failing -stress-min-delta instead of warning")
+	flag.IntVar(&cfg.MaxStressPerNode, "max-stress-per-node", cfg.MaxStressPerNode, "stress at most this many pods on the same node at once, waiting for a slot before the exec (0 = no cap)")
+	flag.StringVar(&cfg.Container, "container", cfg.Container, "container the stress command execs into, unless the input row names one as a third field (default: the pod's first container)")
+	flag.StringVar(&cfg.ExcludeContainers, "exclude-containers", cfg.ExcludeContainers, "comma-separated container names not to sample, replacing the built-in sidecar list (istio-proxy, linkerd-proxy, fluent-bit and others)")
+	flag.StringVar(&cfg.ContainerRegex, "container-regex", cfg.ContainerRegex, "sample only containers whose names match this regular expression, e.g. ^app(-v[0-9]+)?$, after -exclude-containers")
+	flag.BoolVar(&cfg.IncludeSystemContainers, "include-system-containers", cfg.IncludeSystemContainers, "also sample the built-in list of mesh and logging sidecars")
+	flag.StringVar(&cfg.CustomMetric, "custom-metric", cfg.CustomMetric, "name of a pod metric from custom.metrics.k8s.io to add as an output column")
+	flag.StringVar(&cfg.CustomMetricAPI, "custom-metric-api", cfg.CustomMetricAPI, "custom.metrics.k8s.io version to query, e.g. v1beta2 (default: preferred version from discovery)")
+	flag.StringVar(&cfg.Format, "format", cfg.Format, "output format: csv, json or markdown, or several comma-separated, e.g. csv,json, each after the first written to -output with its own extension")
+	flag.BoolVar(&cfg.CSVCRLF, "csv-crlf", cfg.CSVCRLF, "end CSV rows with \\r\\n instead of \\n")
+	flag.BoolVar(&cfg.CSVAlwaysQuote, "csv-always-quote", cfg.CSVAlwaysQuote, "quote every CSV field, not just those that need it")
+	flag.BoolVar(&cfg.Pretty, "pretty", cfg.Pretty, "also print an aligned table of the results, sorted by CPU, to stderr at the end (respects -limit)")
+	flag.StringVar(&cfg.Columns, "columns", cfg.Columns, "comma-separated output columns to keep, in order (default all)")
+	flag.StringVar(&cfg.Sort, "sort", cfg.Sort, "column to sort the output by; prefix with - for descending, e.g. -sort -cpu; ties are ordered by namespace and pod")
+	flag.IntVar(&cfg.Limit, "limit", cfg.Limit, "write at most this many rows after sorting (0 = no limit)")
+	flag.IntVar(&cfg.Precision, "precision", cfg.Precision, "decimal places for CPU and memory; 0 prints whole millicores, higher values print CPU in cores")
+	flag.StringVar(&cfg.MemUnit, "mem-unit", cfg.MemUnit, "unit for memory output: Ki, Mi, Gi or Ti")
+	flag.StringVar(&cfg.Kubeconfig, "kubeconfig", cfg.Kubeconfig, "path to a single kubeconfig file, or an http(s) URL to fetch it from (default: $KUBECONFIG list merged like kubectl, else ~/.kube/config)")
+	flag.BoolVar(&cfg.InsecureSkipTLSVerify, "insecure-skip-tls-verify", cfg.InsecureSkipTLSVerify, "don't verify the API server's certificate (overrides the kubeconfig)")
+	flag.StringVar(&cfg.CertificateAuthority, "certificate-authority", cfg.CertificateAuthority, "CA certificate file for the API server (overrides the kubeconfig)")
+	flag.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "default bound on each Kubernetes API call, for the kinds without their own timeout flag (0 = no limit)")
+	flag.DurationVar(&cfg.GetTimeout, "get-timeout", cfg.GetTimeout, "bound on each Get of a pod, owner or ConfigMap and each pod metrics read (default: -timeout)")
+	flag.DurationVar(&cfg.ListTimeout, "list-timeout", cfg.ListTimeout, "bound on each List call, e.g. one page of targets or a deployment's pods (default: -timeout)")
+	flag.DurationVar(&cfg.ExecTimeout, "exec-timeout", cfg.ExecTimeout, "bound on each stress command exec, whose stream stays open while the pod is sampled, so allow for -stress-duration (default: -timeout)")
+	flag.StringVar(&cfg.As, "as", cfg.As, "user to impersonate, e.g. system:serviceaccount:ns:name; needs RBAC for the impersonate verb on users or serviceaccounts")
+	flag.Var(stringsFlag{&cfg.AsGroups}, "as-group", "`group` to impersonate, repeatable; needs RBAC for the impersonate verb on groups")
+	flag.DurationVar(&cfg.BurstDuration, "burst-duration", cfg.BurstDuration, "sample every -burst-interval for this long before the steady samples, to catch startup spikes (0 = no burst)")
+	flag.DurationVar(&cfg.BurstInterval, "burst-interval", cfg.BurstInterval, "pause between samples during -burst-duration")
+	flag.DurationVar(&cfg.TrendDuration, "trend-duration", cfg.TrendDuration, "sample each pod every -trend-interval for this long instead of the usual five samples, adding a trend column of whether memory rose, fell or stayed flat by a linear fit, e.g. 24h for daily cycles (0 = off)")
+	flag.DurationVar(&cfg.TrendInterval, "trend-interval", cfg.TrendInterval, "pause between samples during -trend-duration")
+	flag.BoolVar(&cfg.ResolveOwner, "resolve-owner", cfg.ResolveOwner, "look up each pod's owning workload; false skips the apps API calls and reports the pod name as the owner, with owner kind \"skipped\"")
+	flag.BoolVar(&cfg.RefreshPod, "refresh-pod", cfg.RefreshPod, "re-fetch the pod before every sample, for pods whose containers change mid-run")
+	flag.Int64Var(&cfg.RetryBudget, "retry-budget", cfg.RetryBudget, "total -wait-for-metrics retries across all pods; once spent, failures are counted without retrying (0 = no limit)")
+	flag.DurationVar(&cfg.PodTimeout, "pod-timeout", cfg.PodTimeout, "bound the total time on one pod, its stress command and all its samples, keeping the samples taken so far and marking the row timed-out (0 = no limit)")
+	flag.DurationVar(&cfg.WaitForMetrics, "wait-for-metrics", cfg.WaitForMetrics, "keep retrying a pod's metrics while the API returns NotFound for up to this long, for freshly started pods (0 = don't retry)")
+	flag.BoolVar(&cfg.HPA, "hpa", cfg.HPA, "add the HPA scaling each pod's owner with its target and current CPU utilization")
+	flag.BoolVar(&cfg.ExtendedMetrics, "extended-metrics", cfg.ExtendedMetrics, "add network rx/tx during sampling and ephemeral storage columns, reported by the kubelet source only (n/a otherwise)")
+	flag.StringVar(&cfg.Template, "template", cfg.Template, "Go text/template executed per result instead of -format, or @file to read it from a file; e.g. '{{.Namespace}}/{{.Pod}}: {{.AvgCPUMilli}}m'")
+	flag.StringVar(&cfg.Source, "source", cfg.Source, "metrics source: metrics-server, kubelet, prometheus, or auto to fall back per pod in that order")
+	flag.StringVar(&cfg.PrometheusURL, "prometheus-url", cfg.PrometheusURL, "Prometheus base URL for -source prometheus or as the last -source auto fallback")
+	flag.StringVar(&cfg.NamespaceMap, "namespace-map", cfg.NamespaceMap, "CSV file of namespace,friendly-name pairs used to relabel namespaces in the output")
+	flag.StringVar(&cfg.Baseline, "baseline", cfg.Baseline, "previous output file (default-column CSV, CSV with a header, or JSON) to compare CPU and memory against per namespace/pod, or namespace/owner for rows without a pod")
+	flag.BoolVar(&cfg.Watch, "watch", cfg.Watch, "measure the targets repeatedly until interrupted, appending to the output")
+	flag.DurationVar(&cfg.WatchInterval, "watch-interval", cfg.WatchInterval, "pause between measurement cycles in -watch mode")
+	flag.StringVar(&cfg.ActiveWindow, "active-window", cfg.ActiveWindow, "in -watch mode, only measure during this daily time range, e.g. 09:00-17:00, idling outside it")
+	flag.StringVar(&cfg.ActiveWindowTZ, "active-window-tz", cfg.ActiveWindowTZ, "IANA time zone for -active-window, e.g. Europe/Berlin (default local time)")
+	flag.Float64Var(&cfg.EMAAlpha, "ema-alpha", cfg.EMAAlpha, "in -watch mode, report each row's exponential moving average of cpu and memory with this weight for the newest cycle, in (0, 1], adding cpu_raw and memory_raw columns of the readings (0 = raw readings)")
+	flag.Int64Var(&cfg.MaxOutputSize, "max-output-size", cfg.MaxOutputSize, "in -watch mode, rotate the output file, and each further -format file, once it reaches this many bytes (0 = no limit)")
+	flag.DurationVar(&cfg.RotateInterval, "rotate-interval", cfg.RotateInterval, "in -watch mode, rotate the output file, and each further -format file, at this interval (0 = never)")
+	flag.IntVar(&cfg.MaxBackups, "max-backups", cfg.MaxBackups, "rotated files to keep per output file (0 = keep all)")
+	flag.BoolVar(&cfg.CPURate, "cpu-rate", cfg.CPURate, "report CPU as the rate over the sampling window instead of the mean of point samples")
+	flag.Float64Var(&cfg.OOMRiskThreshold, "oom-risk-threshold", cfg.OOMRiskThreshold, "flag rows whose peak memory reaches this percentage of a container's memory limit")
+	flag.BoolVar(&cfg.TrackUID, "track-uid", cfg.TrackUID, "add a uid column of the measured pod UIDs, so a pod recreated under the same name in -watch mode starts a new series, and log such replacements")
+	flag.BoolVar(&cfg.IncludeRunID, "include-run-id", cfg.IncludeRunID, "add a run_id column with the UUID logged at the start and end of the run")
+	flag.BoolVar(&cfg.PerContainer, "per-container", cfg.PerContainer, "write one row per container instead of per pod or deployment, adding container, cpu_request, cpu_limit, memory_request and memory_limit columns from its own resources (n/a where unset)")
+	flag.BoolVar(&cfg.Anonymize, "anonymize", cfg.Anonymize, "replace namespace, workload, pod and HPA names with stable aliases like pod-1, for sharing reports; drop image columns with -columns too")
+	flag.StringVar(&cfg.AnonymizeMap, "anonymize-map", cfg.AnonymizeMap, "file to write the kind,alias,original rows of -anonymize to")
+	flag.StringVar(&cfg.SQLite, "sqlite", cfg.SQLite, "also append results to the results table of this SQLite database, created if absent, with a run ID and timestamp")
+	flag.Float64Var(&cfg.CPUPrice, "cpu-price", cfg.CPUPrice, "price per core-hour; with -mem-price, adds an estimated cost column and total")
+	flag.Float64Var(&cfg.MemPrice, "mem-price", cfg.MemPrice, "price per GiB-hour of memory for the cost column")
+	flag.DurationVar(&cfg.CostDuration, "duration", cfg.CostDuration, "how long the measured usage is assumed to last for the cost column, e.g. 730h for a month")
+	flag.BoolVar(&cfg.CompareRequests, "compare-requests", cfg.CompareRequests, "log the rows with the most requested but unused memory, then CPU, at the end of the run")
+	flag.IntVar(&cfg.TopWasteful, "top-wasteful", cfg.TopWasteful, "rows -compare-requests lists (0 = all)")
+	flag.StringVar(&cfg.FailOn, "fail-on", cfg.FailOn, "exit with code 4 if any row matches this predicate, logging each, for CI gates; e.g. mem_ratio>0.95. Fields: mem_ratio and cpu_ratio of limits, cpu_request_ratio, cpu_milli and memory_bytes; operators > >= < <= ==")
+	flag.StringVar(&cfg.ExportURL, "export-url", cfg.ExportURL, "also POST results as JSON arrays to this HTTP endpoint as they are computed")
+	flag.IntVar(&cfg.ExportBatchSize, "export-batch-size", cfg.ExportBatchSize, "results per -export-url request")
+	flag.IntVar(&cfg.ExportRetries, "export-retries", cfg.ExportRetries, "times to retry a failed -export-url request before dropping the batch")
+	flag.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", cfg.OTLPEndpoint, "also export CPU and memory gauges per pod as OTLP/HTTP metrics to this collector URL, e.g. http://otel-collector:4318 (path defaults to /v1/metrics)")
+	flag.StringVar(&cfg.AlertWebhook, "alert-webhook", cfg.AlertWebhook, "at the end of the run, POST a JSON summary of the rows over -oom-risk-threshold or -alert-cpu-threshold to this URL, e.g. a Slack incoming webhook; nothing is sent without breaches")
+	flag.Float64Var(&cfg.AlertCPUThreshold, "alert-cpu-threshold", cfg.AlertCPUThreshold, "for -alert-webhook, also report rows whose CPU reaches this percentage of a container's CPU limit, where it is throttled (0 = off)")
+	flag.BoolVar(&cfg.RequireReady, "require-ready", cfg.RequireReady, "skip pods whose Ready condition isn't True instead of sampling them")
+	flag.BoolVar(&cfg.ReportZero, "report-zero", cfg.ReportZero, "report pods whose sampled CPU and memory are both exactly zero; -report-zero=false omits them and counts them in the summary")
+	flag.DurationVar(&cfg.MinAge, "min-age", cfg.MinAge, "skip pods created less than this long ago, whose usage may not have settled yet; every row has an age_seconds column (0 = measure all)")
+	flag.DurationVar(&cfg.WaitForPods, "wait-for-pods", cfg.WaitForPods, "before sampling, poll for up to this long until every target pod exists and is Running, then log the ones that never were (0 = don't wait)")
+	flag.Int64Var(&cfg.ListPageSize, "list-page-size", cfg.ListPageSize, "list pods and deployments in pages of this many, starting to measure as the first pages arrive when nothing needs the full list (0 = one unpaged List call)")
+	flag.BoolVar(&cfg.IncludeSelf, "include-self", cfg.IncludeSelf, "also measure the pod this tool runs in, found from the POD_NAME and POD_NAMESPACE downward API variables, which is skipped by default")
+	flag.BoolVar(&cfg.IncludeTerminated, "include-terminated", cfg.IncludeTerminated, "measure Succeeded and Failed pods, e.g. finished Jobs, by their peak cpu and memory over their lifetime from -prometheus-url, instead of skipping them")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	diagnose := flag.Bool("diagnose", false, "check the kubeconfig, API server, metrics-server and RBAC for pods, replicasets, exec and metrics, print a PASS/FAIL report and exit without sampling")
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
 
-		// Stress the pod (adjust the number of iterations as needed)
-		for i := 0; i < 5; i++ {
-			// Get resource usage metrics
-			pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
-			if err != nil {
-				klog.Errorf("Error getting pod: %v", err)
-				continue
-			}
-
-			// Fetch and calculate container metrics
-			for _, containerMetric := range pod.Status.ContainerStatuses {
-				containerMetrics, err := metricsClient.MetricsV1beta1().PodMetricses(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
-				if err != nil {
-					klog.Errorf("Error getting pod metrics: %v", err)
-					continue
-				}
-
-				// Filter metrics for the specific container
-				var containerUsage v1.ResourceList
-				for _, container := range containerMetrics.Containers {
-					if container.Name == containerMetric.Name {
-						containerUsage = container.Usage
-						break
-					}
-				}
-
-				if containerUsage != nil {
-					cpuUsage := containerUsage[v1.ResourceCPU]
-					memoryUsage := containerUsage[v1.ResourceMemory]
-
-					cpuTotalMilli += cpuUsage.MilliValue()
-					memoryTotal += memoryUsage.Value()
-					numContainers++
-				}
-			}
-
-			// Wait for some time to stress the pod
-			time.Sleep(1 * time.Second) // Adjust the duration as needed
+	outputSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "output" {
+			outputSet = true
 		}
+	})
+	if outputSet && cfg.OutputDir != "" {
+		klog.Fatalf("-output and -output-dir are mutually exclusive")
+	}
 
-		// Calculate average metrics
-		var avgCPUMilli int64
-		var avgMemoryBytes int64
-
-		if numContainers > 0 {
-			avgCPUMilli = cpuTotalMilli / int64(numContainers)
-			avgMemoryBytes = memoryTotal / int64(numContainers)
-		}
+	// Stop on Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// Write average metrics to CSV
-		row := []string{
-			deploymentName, // Changed to deploymentName
-			fmt.Sprintf("%d"+"m", avgCPUMilli),
-			fmt.Sprintf("%.0f"+"Mi", float64(avgMemoryBytes)/(1024*1024)),
-		}
-		err = metricsWriter.Write(row)
-		if err != nil {
-			klog.Errorf("Error writing metrics CSV row: %v", err)
+	if *diagnose {
+		if !stress.Diagnose(ctx, cfg, os.Stdout) {
+			klog.Flush()
+			os.Exit(exitDiagnoseFailed)
 		}
-
-		// Flush the writer after each pod
-		metricsWriter.Flush()
-
-		klog.Infof("Finished stressing pod: %s in namespace: %s", podName, namespace)
+		return
 	}
 
-	klog.Info("All pods stressed. Average metrics exported to metrics.csv")
+	// Exit with exitInterrupted or exitDeadlineExceeded if a signal or
+	// -max-runtime cut the run short, or exitFailOn if rows matched
+	// -fail-on. os.Exit skips deferred calls, so the log is flushed first.
+	_, err := stress.Run(ctx, cfg)
+	switch {
+	case errors.Is(err, context.Canceled):
+		klog.Flush()
+		os.Exit(exitInterrupted)
+	case errors.Is(err, context.DeadlineExceeded):
+		klog.Flush()
+		os.Exit(exitDeadlineExceeded)
+	case errors.Is(err, stress.ErrFailOn):
+		klog.Errorf("Error: %v", err)
+		klog.Flush()
+		os.Exit(exitFailOn)
+	case err != nil:
+		klog.Fatalf("Error: %v", err)
+	}
 }
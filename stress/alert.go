@@ -0,0 +1,113 @@
+package stress
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Breach reasons reported to -alert-webhook.
+const (
+	breachOOMRisk     = "oom_risk"
+	breachCPUThrottle = "cpu_throttle"
+)
+
+// Breach is a row over an -alert-webhook threshold: its peak memory, or its
+// CPU, as a percentage of a container's limit. Name is the pod, or the
+// owner of a row without one, and Container is set for -per-container rows.
+type Breach struct {
+	Namespace string  `json:"namespace"`
+	Name      string  `json:"name"`
+	Container string  `json:"container,omitempty"`
+	Reason    string  `json:"reason"`
+	Percent   float64 `json:"percent"`
+}
+
+// breachKey identifies a breach across -watch cycles.
+type breachKey struct {
+	namespace, name, container, reason string
+}
+
+// alertReport collects the rows breaching the -oom-risk-threshold or
+// -alert-cpu-threshold for -alert-webhook. A zero cpuThreshold leaves CPU
+// unchecked. A row breaching again in a later -watch cycle is reported
+// once, at its highest percentage.
+type alertReport struct {
+	url          string
+	oomThreshold float64
+	cpuThreshold float64
+	client       *http.Client
+	breaches     []Breach
+	index        map[breachKey]int
+}
+
+func newAlertReport(url string, oomThreshold, cpuThreshold float64) *alertReport {
+	return &alertReport{
+		url: url, oomThreshold: oomThreshold, cpuThreshold: cpuThreshold,
+		client: &http.Client{Timeout: 30 * time.Second},
+		index:  make(map[breachKey]int),
+	}
+}
+
+func (a *alertReport) add(r *PodResult) {
+	if percent, ok := r.PeakMemoryLimitPercent(); ok && percent >= a.oomThreshold {
+		a.record(r, breachOOMRisk, percent)
+	}
+	if percent, ok := r.CPULimitPercent(); ok && a.cpuThreshold > 0 && percent >= a.cpuThreshold {
+		a.record(r, breachCPUThrottle, percent)
+	}
+}
+
+// record adds a breach of the row, or raises the percentage of the one it
+// already has for reason.
+func (a *alertReport) record(r *PodResult, reason string, percent float64) {
+	name := r.Pod
+	if name == "" {
+		name = r.Owner
+	}
+	key := breachKey{namespace: r.Namespace, name: name, container: r.Container, reason: reason}
+	if i, ok := a.index[key]; ok {
+		if percent > a.breaches[i].Percent {
+			a.breaches[i].Percent = percent
+		}
+		return
+	}
+	a.index[key] = len(a.breaches)
+	a.breaches = append(a.breaches, Breach{Namespace: r.Namespace, Name: name, Container: r.Container, Reason: reason, Percent: percent})
+}
+
+// alertPayload is the JSON POSTed to -alert-webhook. Text is a summary
+// line, which is all a Slack incoming webhook shows.
+type alertPayload struct {
+	Text     string   `json:"text"`
+	RunID    string   `json:"run_id"`
+	Breaches []Breach `json:"breaches"`
+}
+
+// send POSTs the breaches, if there are any. Like the exporter it doesn't
+// use the run's context, so the alert still goes out after an interrupt.
+func (a *alertReport) send(runID string) error {
+	if len(a.breaches) == 0 {
+		return nil
+	}
+	payload := alertPayload{
+		Text:     fmt.Sprintf("stresstest run %s: %d rows over their resource limit thresholds", runID, len(a.breaches)),
+		RunID:    runID,
+		Breaches: a.breaches,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
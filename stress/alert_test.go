@@ -0,0 +1,33 @@
+package stress
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAlertReportDedupesBreaches(t *testing.T) {
+	row := func(pod string, peak int64) *PodResult {
+		return &PodResult{
+			Namespace:  "default",
+			Pod:        pod,
+			Owner:      "web",
+			Containers: []ContainerUsage{{Name: "app", PeakMemoryBytes: peak, MemoryLimitBytes: 100}},
+		}
+	}
+	a := newAlertReport("http://alerts.invalid", 90, 0)
+	// Two -watch cycles of two replicas, and a deployment row without a pod
+	a.add(row("web-1", 92))
+	a.add(row("web-2", 95))
+	a.add(row("web-1", 97))
+	a.add(row("web-2", 91))
+	a.add(row("", 93))
+
+	want := []Breach{
+		{Namespace: "default", Name: "web-1", Reason: breachOOMRisk, Percent: 97},
+		{Namespace: "default", Name: "web-2", Reason: breachOOMRisk, Percent: 95},
+		{Namespace: "default", Name: "web", Reason: breachOOMRisk, Percent: 93},
+	}
+	if !reflect.DeepEqual(a.breaches, want) {
+		t.Errorf("breaches = %+v, want %+v", a.breaches, want)
+	}
+}
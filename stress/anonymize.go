@@ -0,0 +1,69 @@
+package stress
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+)
+
+// anonymizer renames namespaces, workloads, pods and HPAs for -anonymize,
+// numbering each kind in order of first appearance so the same original
+// gets the same alias for the whole run. Workload, pod and HPA names are
+// keyed by their original namespace, since names repeat across namespaces.
+type anonymizer struct {
+	aliases  map[string]map[string]string
+	mappings [][]string
+}
+
+func newAnonymizer() *anonymizer {
+	return &anonymizer{aliases: make(map[string]map[string]string)}
+}
+
+// alias returns the stable alias of original, such as pod-3.
+func (a *anonymizer) alias(kind, original string) string {
+	if original == "" {
+		return ""
+	}
+	aliases, ok := a.aliases[kind]
+	if !ok {
+		aliases = make(map[string]string)
+		a.aliases[kind] = aliases
+	}
+	if alias, ok := aliases[original]; ok {
+		return alias
+	}
+	alias := fmt.Sprintf("%s-%d", kind, len(aliases)+1)
+	aliases[original] = alias
+	a.mappings = append(a.mappings, []string{kind, alias, original})
+	return alias
+}
+
+// apply replaces the names of a result, leaving its measurements alone.
+func (a *anonymizer) apply(r *PodResult) {
+	namespace := r.Namespace
+	r.Namespace = a.alias("namespace", namespace)
+	r.Owner = a.alias("workload", namespace+"/"+r.Owner)
+	if r.Pod != "" {
+		r.Pod = a.alias("pod", namespace+"/"+r.Pod)
+	}
+	if r.HPA != nil && r.HPA.Name != "" {
+		hpa := *r.HPA
+		hpa.Name = a.alias("hpa", namespace+"/"+hpa.Name)
+		r.HPA = &hpa
+	}
+}
+
+// writeMapping writes the kind,alias,original rows assigned so far, for
+// de-anonymizing a report internally.
+func (a *anonymizer) writeMapping(path string) error {
+	mappingFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	mappingCSV := csv.NewWriter(mappingFile)
+	if err := mappingCSV.WriteAll(a.mappings); err != nil {
+		mappingFile.Close()
+		return err
+	}
+	return mappingFile.Close()
+}
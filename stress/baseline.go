@@ -0,0 +1,154 @@
+package stress
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Baseline statuses of a row, see BaselineDelta.
+const (
+	baselineMatched = "matched"
+	baselineNew     = "new"
+	baselineMissing = "missing"
+)
+
+// BaselineDelta relates a result to the -baseline row of the same pod,
+// or of the same owner for rows without one. CPUMilli and MemoryBytes are
+// the baseline's figures, zero for rows that are new relative to it.
+type BaselineDelta struct {
+	Status      string
+	CPUMilli    int64
+	MemoryBytes int64
+}
+
+// baselineEntry is one row of a previous output file.
+type baselineEntry struct {
+	namespace, pod, owner string
+	cpuMilli              int64
+	memoryBytes           int64
+	matched               bool
+}
+
+// baseline holds a previous run's rows, matched by namespace and pod, or by
+// namespace and owner for the deployment and grouped rows that have no pod.
+// When several rows share a key they pair up in output order.
+type baseline struct {
+	entries []*baselineEntry
+	byKey   map[string][]*baselineEntry
+}
+
+// baselineKey identifies a row for matching.
+func baselineKey(namespace, pod, owner string) string {
+	if pod != "" {
+		return namespace + "/" + pod
+	}
+	return namespace + "//" + owner
+}
+
+// readBaseline reads a previous output file. JSON output and CSV starting
+// with a header, as -split-by-namespace files do, may have any columns that
+// include namespace, pod or owner, cpu and memory, read by name; headerless
+// CSV must have been written with the default columns.
+func readBaseline(path string, defaultColumns []string) (*baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading baseline: %w", err)
+	}
+
+	var rows []map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("decoding baseline: %w", err)
+		}
+	} else {
+		records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("reading baseline CSV: %w", err)
+		}
+		columns := defaultColumns
+		if len(records) > 0 && isBaselineHeader(records[0]) {
+			columns, records = records[0], records[1:]
+		} else if len(records) > 0 && len(records[0]) != len(defaultColumns) {
+			return nil, fmt.Errorf("baseline CSV has %d columns and no header, want the %d default columns", len(records[0]), len(defaultColumns))
+		}
+		for _, record := range records {
+			row := make(map[string]string, len(record))
+			for i, value := range record {
+				row[columns[i]] = value
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	b := &baseline{byKey: make(map[string][]*baselineEntry)}
+	for i, row := range rows {
+		cpu, err := resource.ParseQuantity(row["cpu"])
+		if err != nil {
+			return nil, fmt.Errorf("baseline row %d: invalid cpu %q", i+1, row["cpu"])
+		}
+		memory, err := resource.ParseQuantity(row["memory"])
+		if err != nil {
+			return nil, fmt.Errorf("baseline row %d: invalid memory %q", i+1, row["memory"])
+		}
+		entry := &baselineEntry{namespace: row["namespace"], pod: row["pod"], owner: row["owner"], cpuMilli: cpu.MilliValue(), memoryBytes: memory.Value()}
+		key := baselineKey(entry.namespace, entry.pod, entry.owner)
+		b.entries = append(b.entries, entry)
+		b.byKey[key] = append(b.byKey[key], entry)
+	}
+	return b, nil
+}
+
+// isBaselineHeader reports whether a CSV record is a header naming the
+// columns a baseline needs rather than a row.
+func isBaselineHeader(record []string) bool {
+	names := make(map[string]bool, len(record))
+	for _, name := range record {
+		names[name] = true
+	}
+	return names["namespace"] && (names["pod"] || names["owner"]) && names["cpu"] && names["memory"]
+}
+
+// match sets the baseline delta of a result.
+func (b *baseline) match(result *PodResult) {
+	for _, entry := range b.byKey[baselineKey(result.Namespace, result.Pod, result.Owner)] {
+		if !entry.matched {
+			entry.matched = true
+			result.Baseline = &BaselineDelta{Status: baselineMatched, CPUMilli: entry.cpuMilli, MemoryBytes: entry.memoryBytes}
+			return
+		}
+	}
+	result.Baseline = &BaselineDelta{Status: baselineNew}
+}
+
+// missing returns a zero-usage result for every baseline row that no result
+// matched, in baseline order.
+func (b *baseline) missing() []*PodResult {
+	var results []*PodResult
+	for _, entry := range b.entries {
+		if entry.matched {
+			continue
+		}
+		results = append(results, &PodResult{
+			Namespace: entry.namespace,
+			Pod:       entry.pod,
+			Owner:     entry.owner,
+			Baseline:  &BaselineDelta{Status: baselineMissing, CPUMilli: entry.cpuMilli, MemoryBytes: entry.memoryBytes},
+		})
+	}
+	return results
+}
+
+// percentChange formats the change from before to after, e.g. "+12.5%", or
+// n/a when before is zero.
+func percentChange(before, after int64) string {
+	if before == 0 {
+		return notAvailable
+	}
+	return fmt.Sprintf("%+.1f%%", float64(after-before)/float64(before)*100)
+}
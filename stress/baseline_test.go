@@ -0,0 +1,53 @@
+package stress
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBaselineMatchesPods(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"headerless", "default,web-2,web,200m,64Mi\ndefault,web-1,web,100m,32Mi\n"},
+		{"header", "cpu,pod,namespace,memory\n200m,web-2,default,64Mi\n100m,web-1,default,32Mi\n"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "baseline.csv")
+		if err := os.WriteFile(path, []byte(test.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		b, err := readBaseline(path, []string{"namespace", "pod", "owner", "cpu", "memory"})
+		if err != nil {
+			t.Fatalf("%s: readBaseline: %v", test.name, err)
+		}
+
+		// Replicas of one owner match their own pod's row, whatever the order
+		web1 := &PodResult{Namespace: "default", Pod: "web-1", Owner: "web"}
+		web3 := &PodResult{Namespace: "default", Pod: "web-3", Owner: "web"}
+		b.match(web1)
+		b.match(web3)
+		if got := web1.Baseline; got.Status != baselineMatched || got.CPUMilli != 100 || got.MemoryBytes != 32<<20 {
+			t.Errorf("%s: web-1 baseline = %+v, want matched at 100m and 32Mi", test.name, got)
+		}
+		if got := web3.Baseline.Status; got != baselineNew {
+			t.Errorf("%s: web-3 baseline status = %s, want %s", test.name, got, baselineNew)
+		}
+		missing := b.missing()
+		if len(missing) != 1 || missing[0].Pod != "web-2" || missing[0].Baseline.CPUMilli != 200 {
+			t.Errorf("%s: missing = %+v, want only web-2 at 200m", test.name, missing)
+		}
+	}
+}
+
+func TestBaselineRejectsOtherColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.csv")
+	if err := os.WriteFile(path, []byte("default,web-1,100m\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBaseline(path, []string{"namespace", "pod", "owner", "cpu", "memory"}); err == nil {
+		t.Errorf("readBaseline of a headerless CSV without the default columns succeeded")
+	}
+}
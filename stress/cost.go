@@ -0,0 +1,28 @@
+package stress
+
+import "fmt"
+
+// pricing turns measured usage into an estimated cost for -cpu-price and
+// -mem-price, assuming the usage holds for hours.
+type pricing struct {
+	cpuCoreHour float64
+	memGiBHour  float64
+	hours       float64
+}
+
+// enabled reports whether any price was set.
+func (p pricing) enabled() bool {
+	return p.cpuCoreHour > 0 || p.memGiBHour > 0
+}
+
+// cost returns the estimated cost of a result's average usage.
+func (p pricing) cost(r *PodResult) float64 {
+	cores := float64(r.AvgCPUMilli) / 1000
+	gib := float64(r.AvgMemoryBytes) / (1 << 30)
+	return (cores*p.cpuCoreHour + gib*p.memGiBHour) * p.hours
+}
+
+// withCost adds the estimated cost column.
+func (l resultLayout) withCost(p pricing) resultLayout {
+	return append(l, resultColumn{"cost", func(r *PodResult) string { return fmt.Sprintf("%.2f", p.cost(r)) }})
+}
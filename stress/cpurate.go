@@ -0,0 +1,104 @@
+package stress
+
+import "time"
+
+// cpuRateAccumulator averages CPU as a rate over the whole sampling window
+// rather than as a mean of point samples.
+//
+// Where the source exposes a cumulative CPU counter (the kubelet summary
+// API), the rate is simply (counter at end - counter at start) / elapsed for
+// each container. metrics-server has no such counter; each reading is the
+// rate over the reading's Window ending at its Timestamp. The CPU time
+// consumed in that window is therefore usage*Window, and summing it over the
+// distinct windows we observed and dividing by the covered time gives the
+// same result. Repeated reads of the same window (we sample faster than
+// metrics-server scrapes) are only counted once, which is what makes this
+// differ from the point average.
+type cpuRateAccumulator struct {
+	seen         map[cpuWindowKey]bool
+	milliSeconds float64
+	seconds      float64
+
+	counters map[cpuCounterKey]*cpuCounterSpan
+}
+
+type cpuWindowKey struct {
+	namespace string
+	pod       string
+	container string
+	timestamp time.Time
+}
+
+type cpuCounterKey struct {
+	namespace string
+	pod       string
+	container string
+}
+
+// cpuCounterSpan is the first and last cumulative CPU reading of a container.
+type cpuCounterSpan struct {
+	firstTime  time.Time
+	firstNanos uint64
+	lastTime   time.Time
+	lastNanos  uint64
+}
+
+// add records a pod container's CPU reading. Pods are keyed by namespace and
+// name, so same-named pods of different namespaces never share a window.
+// Readings with neither a cumulative counter nor a window are ignored,
+// which leaves rate to report the point-sample fallback.
+func (a *cpuRateAccumulator) add(namespace, pod string, reading *usageReading, container containerReading) {
+	if container.cpuCumulativeNanos > 0 && !reading.timestamp.IsZero() {
+		key := cpuCounterKey{namespace: namespace, pod: pod, container: container.name}
+		if a.counters == nil {
+			a.counters = make(map[cpuCounterKey]*cpuCounterSpan)
+		}
+		span, ok := a.counters[key]
+		if !ok {
+			a.counters[key] = &cpuCounterSpan{
+				firstTime: reading.timestamp, firstNanos: container.cpuCumulativeNanos,
+				lastTime: reading.timestamp, lastNanos: container.cpuCumulativeNanos,
+			}
+		} else if reading.timestamp.After(span.lastTime) {
+			span.lastTime, span.lastNanos = reading.timestamp, container.cpuCumulativeNanos
+		}
+		return
+	}
+
+	window := reading.window
+	if window <= 0 {
+		return
+	}
+	key := cpuWindowKey{namespace: namespace, pod: pod, container: container.name, timestamp: reading.timestamp}
+	if a.seen == nil {
+		a.seen = make(map[cpuWindowKey]bool)
+	}
+	if a.seen[key] {
+		return
+	}
+	a.seen[key] = true
+	a.milliSeconds += float64(container.cpuMilli) * window.Seconds()
+	a.seconds += window.Seconds()
+}
+
+// rate returns the per-container CPU rate in millicores over the observed
+// windows, and false if the source never reported a usable counter or window.
+func (a *cpuRateAccumulator) rate() (int64, bool) {
+	var nanos, elapsed float64
+	for _, span := range a.counters {
+		// Skip containers seen only once, and counter resets from restarts
+		if !span.lastTime.After(span.firstTime) || span.lastNanos < span.firstNanos {
+			continue
+		}
+		nanos += float64(span.lastNanos - span.firstNanos)
+		elapsed += span.lastTime.Sub(span.firstTime).Seconds()
+	}
+	if elapsed > 0 {
+		return int64(nanos / elapsed / 1e6), true
+	}
+
+	if a.seconds <= 0 {
+		return 0, false
+	}
+	return int64(a.milliSeconds / a.seconds), true
+}
@@ -0,0 +1,72 @@
+package stress
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/klog"
+	"k8s.io/metrics/pkg/client/custom_metrics"
+)
+
+var podGroupKind = schema.GroupKind{Kind: "Pod"}
+
+// customMetricReader fetches one named pod metric from the
+// custom.metrics.k8s.io API, the same source HPAs use for Pods metrics.
+type customMetricReader struct {
+	client custom_metrics.CustomMetricsClient
+	name   string
+}
+
+// newCustomMetricReader creates a reader for metric name. An empty apiVersion
+// uses the preferred custom.metrics.k8s.io version advertised by discovery.
+func newCustomMetricReader(config *rest.Config, discoveryClient discovery.DiscoveryInterface, name, apiVersion string) (*customMetricReader, error) {
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	var client custom_metrics.CustomMetricsClient
+	if apiVersion == "" {
+		client = custom_metrics.NewForConfig(config, mapper, custom_metrics.NewAvailableAPIsGetter(discoveryClient))
+	} else {
+		version := schema.GroupVersion{Group: "custom.metrics.k8s.io", Version: apiVersion}
+		var err error
+		client, err = custom_metrics.NewForVersionForConfig(config, mapper, version)
+		if err != nil {
+			return nil, fmt.Errorf("creating custom metrics client for %s: %w", version, err)
+		}
+	}
+	return &customMetricReader{client: client, name: name}, nil
+}
+
+// podValue returns the current value of the metric for a pod.
+func (r *customMetricReader) podValue(namespace, podName string) (resource.Quantity, error) {
+	value, err := r.client.NamespacedMetrics(namespace).GetForObject(podGroupKind, podName, r.name, labels.Everything())
+	if err != nil {
+		return resource.Quantity{}, fmt.Errorf("getting custom metric %s for pod %s/%s: %w", r.name, namespace, podName, err)
+	}
+	return value.Value, nil
+}
+
+// averageColumn fetches the metric for each pod and formats the mean as an
+// output column, or notAvailable if no pod reported a value.
+func (r *customMetricReader) averageColumn(namespace string, podNames ...string) string {
+	var totalMilli int64
+	var count int64
+	for _, podName := range podNames {
+		value, err := r.podValue(namespace, podName)
+		if err != nil {
+			klog.Errorf("Error getting custom metric: %v", err)
+			continue
+		}
+		totalMilli += value.MilliValue()
+		count++
+	}
+	if count == 0 {
+		return notAvailable
+	}
+	return resource.NewMilliQuantity(totalMilli/count, resource.DecimalSI).String()
+}
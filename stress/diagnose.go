@@ -0,0 +1,179 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// diagnosis is the outcome of one -diagnose check.
+type diagnosis struct {
+	check  string
+	status string
+	detail string
+}
+
+const (
+	diagnosisPass = "PASS"
+	diagnosisFail = "FAIL"
+	diagnosisSkip = "SKIP"
+)
+
+// diagnoseTimeout bounds each -diagnose request, so an unreachable API
+// server fails its check instead of hanging.
+const diagnoseTimeout = 10 * time.Second
+
+// diagnosedPermissions are the accesses a run needs, checked by -diagnose
+// with a SelfSubjectAccessReview each.
+var diagnosedPermissions = []authorizationv1.ResourceAttributes{
+	{Verb: "list", Resource: "pods"},
+	{Verb: "get", Resource: "pods"},
+	{Verb: "watch", Resource: "pods"},
+	{Verb: "get", Group: "apps", Resource: "replicasets"},
+	{Verb: "list", Group: "apps", Resource: "deployments"},
+	{Verb: "create", Resource: "pods", Subresource: "exec"},
+	{Verb: "list", Group: "metrics.k8s.io", Resource: "pods"},
+	{Verb: "get", Group: "metrics.k8s.io", Resource: "pods"},
+}
+
+// Diagnose checks that the kubeconfig loads, the API server answers,
+// metrics-server serves pod metrics and the user has the RBAC a run needs,
+// in cfg.Namespace or cluster-wide without one. It writes a PASS, FAIL or
+// SKIP line per check to out and reports whether all passed; no pod is
+// sampled.
+func Diagnose(ctx context.Context, cfg Config, out io.Writer) bool {
+	results := diagnose(ctx, cfg)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	passed := 0
+	for _, result := range results {
+		if result.status == diagnosisPass {
+			passed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.status, result.check, result.detail)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%d of %d checks passed\n", passed, len(results))
+	return passed == len(results)
+}
+
+// diagnose runs the -diagnose checks in order. Once the kubeconfig or the
+// API server fails, the checks after it are skipped.
+func diagnose(ctx context.Context, cfg Config) []diagnosis {
+	checks := []string{"kubeconfig", "api server", "metrics-server"}
+	for _, permission := range diagnosedPermissions {
+		checks = append(checks, "rbac "+describePermission(permission))
+	}
+	var results []diagnosis
+	pass := func(detail string) {
+		results = append(results, diagnosis{check: checks[len(results)], status: diagnosisPass, detail: detail})
+	}
+	fail := func(err error) {
+		results = append(results, diagnosis{check: checks[len(results)], status: diagnosisFail, detail: err.Error()})
+	}
+	skipRest := func() []diagnosis {
+		for _, check := range checks[len(results):] {
+			results = append(results, diagnosis{check: check, status: diagnosisSkip, detail: "needs the checks above"})
+		}
+		return results
+	}
+
+	config, err := clientConfig(ctx, cfg)
+	if err != nil {
+		fail(err)
+		return skipRest()
+	}
+	pass("server " + config.Host)
+	config = rest.CopyConfig(config)
+	config.Timeout = diagnoseTimeout
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fail(fmt.Errorf("creating clientset: %w", err))
+		return skipRest()
+	}
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		fail(err)
+		return skipRest()
+	}
+	pass("reachable, Kubernetes " + version.GitVersion)
+
+	if detail, err := diagnoseMetricsAPI(ctx, config, clientset); err != nil {
+		fail(err)
+	} else {
+		pass(detail)
+	}
+	for _, permission := range diagnosedPermissions {
+		if err := diagnosePermission(ctx, clientset, cfg.Namespace, permission); err != nil {
+			fail(err)
+		} else {
+			pass("allowed")
+		}
+	}
+	return results
+}
+
+// diagnoseMetricsAPI checks that metrics.k8s.io is registered and answers
+// a pod metrics list. A forbidden list passes, since namespace-scoped users
+// can't list cluster-wide; the RBAC checks report it.
+func diagnoseMetricsAPI(ctx context.Context, config *rest.Config, clientset kubernetes.Interface) (string, error) {
+	if _, err := clientset.Discovery().ServerResourcesForGroupVersion("metrics.k8s.io/v1beta1"); err != nil {
+		return "", fmt.Errorf("metrics.k8s.io/v1beta1 not served, check that metrics-server is installed: %w", err)
+	}
+	metricsClient, err := versioned.NewForConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("creating metrics clientset: %w", err)
+	}
+	_, err = metricsClient.MetricsV1beta1().PodMetricses("").List(ctx, metav1.ListOptions{Limit: 1})
+	if apierrors.IsForbidden(err) {
+		return "registered, listing pod metrics is forbidden", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("metrics-server isn't answering, check that it is ready: %w", err)
+	}
+	return "serving pod metrics", nil
+}
+
+// diagnosePermission asks the API server whether the current user may make
+// the request of permission in namespace.
+func diagnosePermission(ctx context.Context, clientset kubernetes.Interface, namespace string, permission authorizationv1.ResourceAttributes) error {
+	permission.Namespace = namespace
+	review := &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &permission}}
+	review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("checking access: %w", err)
+	}
+	if !review.Status.Allowed {
+		where := "cluster-wide"
+		if namespace != "" {
+			where = "in namespace " + namespace
+		}
+		if review.Status.Reason != "" {
+			return fmt.Errorf("denied %s: %s", where, review.Status.Reason)
+		}
+		return fmt.Errorf("denied %s", where)
+	}
+	return nil
+}
+
+// describePermission names a permission like kubectl auth can-i, e.g.
+// "list pods.metrics.k8s.io".
+func describePermission(permission authorizationv1.ResourceAttributes) string {
+	resource := permission.Resource
+	if permission.Subresource != "" {
+		resource += "/" + permission.Subresource
+	}
+	if permission.Group != "" {
+		resource += "." + permission.Group
+	}
+	return permission.Verb + " " + resource
+}
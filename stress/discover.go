@@ -0,0 +1,316 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// listTargets lists the pods, or deployments, to stress from the cluster
+// instead of reading an input file. With allNamespaces it lists
+// cluster-wide; if that is forbidden and a namespace is given, it falls back
+// to listing just that namespace, so the same flags work under
+// namespace-scoped RBAC. Each of selectors is listed separately and the
+// results unioned, tagging every target with the selectors that matched it;
+// no selectors lists everything.
+func listTargets(ctx context.Context, clientset kubernetes.Interface, namespace string, allNamespaces bool, selectors []string, deployments bool, pageSize int64, timeouts callTimeouts) ([]Target, error) {
+	if allNamespaces {
+		targets, err := listSelectorTargets(ctx, clientset, metav1.NamespaceAll, selectors, deployments, pageSize, timeouts)
+		if err == nil || !apierrors.IsForbidden(err) {
+			return targets, err
+		}
+		if namespace == "" {
+			return nil, fmt.Errorf("not allowed to list cluster-wide; set -namespace to a namespace you can access: %w", err)
+		}
+		klog.Warningf("Not allowed to list cluster-wide, listing namespace %s only: %v", namespace, err)
+	}
+
+	targets, err := listSelectorTargets(ctx, clientset, namespace, selectors, deployments, pageSize, timeouts)
+	if apierrors.IsForbidden(err) {
+		return nil, fmt.Errorf("not allowed to list namespace %s: %w", namespace, err)
+	}
+	return targets, err
+}
+
+// listTargetsByNamespace lists the targets of every namespace, listing up
+// to parallel namespaces at once, for -parallel-namespaces. Targets come
+// out grouped by namespace, in namespace name order.
+func listTargetsByNamespace(ctx context.Context, clientset kubernetes.Interface, selectors []string, deployments bool, pageSize int64, timeouts callTimeouts, parallel int) ([]Target, error) {
+	listCtx, cancel := timeouts.forList(ctx)
+	namespaces, err := clientset.CoreV1().Namespaces().List(listCtx, metav1.ListOptions{})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+
+	listed := make([][]Target, len(namespaces.Items))
+	errs := make([]error, len(namespaces.Items))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, namespace := range namespaces.Items {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, namespace string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			listed[i], errs[i] = listSelectorTargets(ctx, clientset, namespace, selectors, deployments, pageSize, timeouts)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("namespace %s: %w", namespace, errs[i])
+			}
+		}(i, namespace.Name)
+	}
+	wg.Wait()
+
+	var targets []Target
+	for i := range listed {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		targets = append(targets, listed[i]...)
+	}
+	return targets, nil
+}
+
+// listSelectorTargets lists namespace once per selector, in selector order,
+// deduplicating targets matched by more than one.
+func listSelectorTargets(ctx context.Context, clientset kubernetes.Interface, namespace string, selectors []string, deployments bool, pageSize int64, timeouts callTimeouts) ([]Target, error) {
+	if len(selectors) == 0 {
+		return listNamespaceTargets(ctx, clientset, namespace, metav1.ListOptions{Limit: pageSize}, deployments, timeouts)
+	}
+
+	var targets []Target
+	seen := make(map[string]int)
+	for _, selector := range selectors {
+		listed, err := listNamespaceTargets(ctx, clientset, namespace, metav1.ListOptions{LabelSelector: selector, Limit: pageSize}, deployments, timeouts)
+		if err != nil {
+			return nil, fmt.Errorf("selector %q: %w", selector, err)
+		}
+		for _, target := range listed {
+			key := target.Namespace + "/" + target.Name
+			if i, ok := seen[key]; ok {
+				targets[i].Selector += selectorSeparator + selector
+				continue
+			}
+			target.Selector = selector
+			seen[key] = len(targets)
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+// listNamespaceTargets lists the targets of namespace a page of opts.Limit
+// at a time, or all at once without a limit. If a continue token expires
+// on a large, changing cluster, the listing starts over unpaged.
+func listNamespaceTargets(ctx context.Context, clientset kubernetes.Interface, namespace string, opts metav1.ListOptions, deployments bool, timeouts callTimeouts) ([]Target, error) {
+	var targets []Target
+	for {
+		page, next, err := listPage(ctx, clientset, namespace, opts, deployments, timeouts)
+		if apierrors.IsResourceExpired(err) && opts.Continue != "" {
+			klog.Warningf("Listing continue token expired after %d targets, listing again without paging: %v", len(targets), err)
+			targets, opts.Continue, opts.Limit = nil, "", 0
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, page...)
+		if next == "" {
+			return targets, nil
+		}
+		opts.Continue = next
+	}
+}
+
+// listPage lists one page of targets, returning the continue token of the
+// next one, empty after the last.
+func listPage(ctx context.Context, clientset kubernetes.Interface, namespace string, opts metav1.ListOptions, deployments bool, timeouts callTimeouts) ([]Target, string, error) {
+	ctx, cancel := timeouts.forList(ctx)
+	defer cancel()
+	var targets []Target
+	if deployments {
+		list, err := clientset.AppsV1().Deployments(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, "", fmt.Errorf("listing deployments: %w", err)
+		}
+		for _, deployment := range list.Items {
+			targets = append(targets, Target{Namespace: deployment.Namespace, Name: deployment.Name})
+		}
+		return targets, list.Continue, nil
+	}
+
+	list, err := clientset.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("listing pods: %w", err)
+	}
+	for _, pod := range list.Items {
+		targets = append(targets, Target{Namespace: pod.Namespace, Name: pod.Name})
+	}
+	return targets, list.Continue, nil
+}
+
+// streamListTargets lists like listTargets with at most one selector, but
+// sends each page's targets that keep accepts as the page arrives, so that
+// measuring starts before a very large cluster has been listed. The first
+// page is fetched before returning, so its errors, and the fallback from a
+// forbidden cluster-wide list, are those of listTargets. A later page that
+// fails ends the stream with the targets listed so far. Each sent target is
+// first appended to *kept, complete once the channel closes, as in
+// streamCSVTargets.
+func streamListTargets(ctx context.Context, clientset kubernetes.Interface, namespace string, allNamespaces bool, selectors []string, deployments bool, pageSize int64, timeouts callTimeouts, keep func(Target) bool, kept *[]Target) (<-chan Target, error) {
+	opts := metav1.ListOptions{Limit: pageSize}
+	var selector string
+	if len(selectors) > 0 {
+		selector = selectors[0]
+		opts.LabelSelector = selector
+	}
+	listNamespace := namespace
+	if allNamespaces {
+		listNamespace = metav1.NamespaceAll
+	}
+	page, next, err := listPage(ctx, clientset, listNamespace, opts, deployments, timeouts)
+	if allNamespaces && apierrors.IsForbidden(err) {
+		if namespace == "" {
+			return nil, fmt.Errorf("not allowed to list cluster-wide; set -namespace to a namespace you can access: %w", err)
+		}
+		klog.Warningf("Not allowed to list cluster-wide, listing namespace %s only: %v", namespace, err)
+		listNamespace = namespace
+		page, next, err = listPage(ctx, clientset, listNamespace, opts, deployments, timeouts)
+	}
+	if apierrors.IsForbidden(err) {
+		return nil, fmt.Errorf("not allowed to list namespace %s: %w", namespace, err)
+	}
+	if err != nil && selector != "" {
+		return nil, fmt.Errorf("selector %q: %w", selector, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Target)
+	go func() {
+		defer close(out)
+		for pages := 1; ; pages++ {
+			for _, target := range page {
+				target.Selector = selector
+				if !keep(target) {
+					continue
+				}
+				*kept = append(*kept, target)
+				select {
+				case out <- target:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if next == "" {
+				klog.V(1).Infof("Listed %d pages of targets, kept %d", pages, len(*kept))
+				return
+			}
+			opts.Continue = next
+			page, next, err = listPage(ctx, clientset, listNamespace, opts, deployments, timeouts)
+			if err != nil {
+				klog.Errorf("Error listing the next page of targets, measuring the %d listed so far: %v", len(*kept), err)
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// discoverNamespaces fills in the namespace of single-field pod rows for
+// -discover-namespace, listing pods cluster-wide once and matching them by
+// name. Rows whose name matches no pod are logged and dropped, and names
+// found in several namespaces fail the run, listing each, since measuring
+// an arbitrary one would be misleading; other rows are kept as they are.
+func discoverNamespaces(ctx context.Context, clientset kubernetes.Interface, records [][]string, pageSize int64, timeouts callTimeouts) ([][]string, error) {
+	var missing bool
+	for _, record := range records {
+		if len(record) == 1 {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return records, nil
+	}
+
+	pods, err := listNamespaceTargets(ctx, clientset, metav1.NamespaceAll, metav1.ListOptions{Limit: pageSize}, false, timeouts)
+	if err != nil {
+		return nil, fmt.Errorf("listing pods to discover namespaces: %w", err)
+	}
+	namespaces := make(map[string][]string)
+	for _, pod := range pods {
+		namespaces[pod.Name] = append(namespaces[pod.Name], pod.Namespace)
+	}
+
+	resolved := make([][]string, 0, len(records))
+	var ambiguous []string
+	for i, record := range records {
+		if len(record) != 1 {
+			resolved = append(resolved, record)
+			continue
+		}
+		name := strings.TrimSpace(record[0])
+		switch matches := namespaces[name]; len(matches) {
+		case 0:
+			klog.Errorf("Skipping input row %d: no pod named %q in any namespace", i+1, name)
+		case 1:
+			klog.Infof("Discovered namespace %s for pod %s", matches[0], name)
+			resolved = append(resolved, []string{name, matches[0]})
+		default:
+			ambiguous = append(ambiguous, fmt.Sprintf("%s (row %d, in %s)", name, i+1, strings.Join(matches, ",")))
+		}
+	}
+	if len(ambiguous) > 0 {
+		return nil, fmt.Errorf("pod names found in several namespaces, give their namespace in the input: %s", strings.Join(ambiguous, "; "))
+	}
+	return resolved, nil
+}
+
+// capTargetsPerNamespace enforces -max-pods-per-namespace: a namespace
+// with more than max targets is an error, or with truncate keeps only its
+// first max targets. It returns the kept targets and how many were dropped.
+func capTargetsPerNamespace(targets []Target, max int, truncate bool) ([]Target, int, error) {
+	counts := make(map[string]int)
+	kept := make([]Target, 0, len(targets))
+	for _, target := range targets {
+		counts[target.Namespace]++
+		if counts[target.Namespace] <= max {
+			kept = append(kept, target)
+			continue
+		}
+		if !truncate {
+			return nil, 0, fmt.Errorf("namespace %s has more than %d targets; narrow the selection, raise -max-pods-per-namespace or set -truncate-per-namespace", target.Namespace, max)
+		}
+	}
+	for namespace, count := range counts {
+		if count > max {
+			klog.Warningf("Truncated namespace %s from %d to %d targets (-max-pods-per-namespace)", namespace, count, max)
+		}
+	}
+	return kept, len(targets) - len(kept), nil
+}
+
+// selectorSeparator joins the selectors that matched a target in the
+// selector column; commas already separate a selector's requirements.
+const selectorSeparator = ";"
+
+// sampleTargets keeps each target with probability rate, drawing from rng so
+// a fixed seed keeps the same targets.
+func sampleTargets(targets []Target, rate float64, rng *rand.Rand) []Target {
+	kept := make([]Target, 0, int(float64(len(targets))*rate)+1)
+	for _, target := range targets {
+		if rng.Float64() < rate {
+			kept = append(kept, target)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,32 @@
+package stress
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoverNamespaces(t *testing.T) {
+	pod := func(namespace, name string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	clientset := fake.NewSimpleClientset(pod("team-a", "web-1"), pod("team-a", "db-1"), pod("team-b", "db-1"))
+
+	records, err := discoverNamespaces(context.Background(), clientset, [][]string{{"web-1"}, {"gone-1"}, {"db-1", "team-b"}}, 0, callTimeouts{})
+	if err != nil {
+		t.Fatalf("discoverNamespaces: %v", err)
+	}
+	if want := [][]string{{"web-1", "team-a"}, {"db-1", "team-b"}}; !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %q, want %q", records, want)
+	}
+
+	_, err = discoverNamespaces(context.Background(), clientset, [][]string{{"web-1"}, {"db-1"}}, 0, callTimeouts{})
+	if err == nil || !strings.Contains(err.Error(), "db-1 (row 2, in team-a,team-b)") {
+		t.Errorf("discoverNamespaces of an ambiguous name: error = %v, want it to list db-1", err)
+	}
+}
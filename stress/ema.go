@@ -0,0 +1,52 @@
+package stress
+
+import (
+	"math"
+	"strings"
+)
+
+// emaSmoother keeps the -ema-alpha exponential moving average of each
+// row's CPU and memory across -watch cycles, so dashboards see a damped
+// series instead of every cycle's noise.
+type emaSmoother struct {
+	alpha  float64
+	series map[string]emaAverage
+}
+
+type emaAverage struct {
+	cpu, memory float64
+}
+
+func newEMASmoother(alpha float64) *emaSmoother {
+	return &emaSmoother{alpha: alpha, series: make(map[string]emaAverage)}
+}
+
+// smooth blends the result's CPU and memory into its series' averages as
+// alpha*reading + (1-alpha)*average and reports those instead, keeping the
+// readings in RawCPUMilli and RawMemoryBytes. A series starts at its first
+// reading; rows without samples are reported raw and leave it unchanged.
+func (e *emaSmoother) smooth(result *PodResult) {
+	result.RawCPUMilli = result.AvgCPUMilli
+	result.RawMemoryBytes = result.AvgMemoryBytes
+	if result.Samples == 0 {
+		return
+	}
+	key := emaSeriesKey(result)
+	average, ok := e.series[key]
+	if ok {
+		average.cpu = e.alpha*float64(result.AvgCPUMilli) + (1-e.alpha)*average.cpu
+		average.memory = e.alpha*float64(result.AvgMemoryBytes) + (1-e.alpha)*average.memory
+	} else {
+		average = emaAverage{cpu: float64(result.AvgCPUMilli), memory: float64(result.AvgMemoryBytes)}
+	}
+	e.series[key] = average
+	result.AvgCPUMilli = int64(math.Round(average.cpu))
+	result.AvgMemoryBytes = int64(math.Round(average.memory))
+}
+
+// emaSeriesKey identifies the row a result continues from the cycles
+// before; with -track-uid, a pod recreated under the same name starts a
+// new series.
+func emaSeriesKey(result *PodResult) string {
+	return strings.Join([]string{result.Namespace, result.Pod, result.Container, result.OwnerKind, result.Owner, result.UID}, "/")
+}
@@ -0,0 +1,316 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/klog"
+)
+
+// Run modes accepted by -mode: sample metrics, apply the -stress load
+// without recording anything, or both at once.
+const (
+	modeMetrics = "metrics"
+	modeStress  = "stress"
+	modeBoth    = "both"
+)
+
+// checkMode validates -mode against the stress flags: metrics mode applies
+// no load, so -stress and -stress-command need stress or both, which
+// default to cpu stress.
+func checkMode(mode, stress, stressCommand string) error {
+	switch mode {
+	case modeMetrics:
+		if stress != "" || stressCommand != "" {
+			return fmt.Errorf("-stress and -stress-command apply load, which -mode %s doesn't; use -mode %s or %s", modeMetrics, modeBoth, modeStress)
+		}
+		return nil
+	case modeStress, modeBoth:
+		return nil
+	default:
+		return fmt.Errorf("unknown -mode %q, want %s, %s or %s", mode, modeMetrics, modeStress, modeBoth)
+	}
+}
+
+// Stress modes accepted by -stress, each with a default command that only
+// needs a POSIX shell and coreutils or busybox in the image.
+const (
+	stressCPU    = "cpu"
+	stressMemory = "memory"
+)
+
+var defaultStressCommands = map[string]string{
+	stressCPU:    `timeout {{.DurationSeconds}} sh -c 'while :; do :; done'`,
+	stressMemory: `timeout {{.DurationSeconds}} sh -c 'head -c $(({{.MemMB}} * 1024 * 1024)) /dev/zero | tail'`,
+}
+
+// stressCommandData is what a -stress-command template is rendered with for
+// each pod.
+type stressCommandData struct {
+	Namespace       string
+	Pod             string
+	Container       string
+	DurationSeconds int
+	MemMB           int
+}
+
+// parseStressCommand returns the command template for -stress mode, or the
+// -stress-command override of it, read from a file if it starts with "@".
+// A -stress-command alone stresses in cpu mode. Both empty disables stress
+// and returns nil.
+func parseStressCommand(mode, command string) (*template.Template, error) {
+	if mode == "" && command == "" {
+		return nil, nil
+	}
+	if mode == "" {
+		mode = stressCPU
+	}
+	defaultCommand, ok := defaultStressCommands[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown -stress mode %q, want %s or %s", mode, stressCPU, stressMemory)
+	}
+	if command == "" {
+		command = defaultCommand
+	}
+	if strings.HasPrefix(command, "@") {
+		data, err := os.ReadFile(strings.TrimPrefix(command, "@"))
+		if err != nil {
+			return nil, fmt.Errorf("reading -stress-command: %w", err)
+		}
+		command = string(data)
+	}
+	tmpl, err := template.New("stress").Option("missingkey=error").Parse(command)
+	if err != nil {
+		return nil, fmt.Errorf("parsing -stress-command: %w", err)
+	}
+	return tmpl, nil
+}
+
+// stressor runs the stress command in a pod's container through the exec
+// API while the pod is sampled. The exec stream is closed when sampling
+// ends, but the command may keep running in the container, so it should
+// bound itself, as the defaults do with timeout.
+type stressor struct {
+	clientset kubernetes.Interface
+	config    *rest.Config
+	command   *template.Template
+	duration  time.Duration
+	memMB     int
+	timeouts  callTimeouts
+	nodeSlots *nodeSlots
+
+	// verify, if set, checks the command raised the container's usage,
+	// read with readUsage.
+	verify    *stressVerification
+	readUsage func(ctx context.Context, pod *v1.Pod, container string) (containerReading, error)
+}
+
+// stressVerification configures the -stress-min-delta check: after delay,
+// the container's CPU (millicores), or memory (bytes) in memory mode, must
+// have risen by minDelta since just before the command started. A failed
+// check is logged, or with abort skips the pod.
+type stressVerification struct {
+	memory   bool
+	minDelta int64
+	delay    time.Duration
+	abort    bool
+}
+
+// StressCheck is the outcome of the -stress-min-delta check of a pod: the
+// rise in CPU millicores, or memory bytes in memory mode, and whether it
+// reached the minimum.
+type StressCheck struct {
+	Passed bool
+	Memory bool
+	Delta  int64
+}
+
+// start runs the stress command in the container and, with verify, checks
+// that it took effect. It returns a func that stops the command and the
+// check, nil without verify or when usage couldn't be read. With
+// -max-stress-per-node it first waits for a slot on the pod's node, held
+// until the command is stopped.
+func (s *stressor) start(ctx context.Context, pod *v1.Pod, containerName string) (func(), *StressCheck, error) {
+	container, err := execContainer(pod, containerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	release, err := s.nodeSlots.acquire(ctx, pod)
+	if err != nil {
+		return nil, nil, err
+	}
+	stop, check, err := s.run(ctx, pod, container)
+	if stop == nil {
+		release()
+		return nil, nil, err
+	}
+	return func() {
+		stop()
+		release()
+	}, check, err
+}
+
+// run execs the stress command into container and runs the verify check.
+func (s *stressor) run(ctx context.Context, pod *v1.Pod, container string) (func(), *StressCheck, error) {
+	if s.verify == nil {
+		stop, err := s.exec(ctx, pod, container)
+		return stop, nil, err
+	}
+
+	before, beforeErr := s.readUsage(ctx, pod, container)
+	stop, err := s.exec(ctx, pod, container)
+	if err != nil {
+		return nil, nil, err
+	}
+	if beforeErr != nil {
+		klog.Warningf("Not verifying stress command in pod %s/%s, reading usage: %v", pod.Namespace, pod.Name, beforeErr)
+		return stop, nil, nil
+	}
+	if !sleepContext(ctx, s.verify.delay) {
+		return stop, nil, nil
+	}
+	after, err := s.readUsage(ctx, pod, container)
+	if err != nil {
+		klog.Warningf("Not verifying stress command in pod %s/%s, reading usage: %v", pod.Namespace, pod.Name, err)
+		return stop, nil, nil
+	}
+
+	check := &StressCheck{Memory: s.verify.memory, Delta: after.cpuMilli - before.cpuMilli}
+	if s.verify.memory {
+		check.Delta = after.memoryBytes - before.memoryBytes
+	}
+	check.Passed = check.Delta >= s.verify.minDelta
+	if !check.Passed {
+		if s.verify.abort {
+			stop()
+			return nil, nil, fmt.Errorf("stress command in pod %s/%s container %s raised usage by only %d, below -stress-min-delta", pod.Namespace, pod.Name, container, check.Delta)
+		}
+		klog.Warningf("Stress command in pod %s/%s container %s raised usage by only %d, below -stress-min-delta; it may have failed", pod.Namespace, pod.Name, container, check.Delta)
+	}
+	return stop, check, nil
+}
+
+// nodeSlots limits the pods stressed at once on each node, for
+// -max-stress-per-node, with a semaphore per node name created on first
+// use. A nil nodeSlots doesn't limit.
+type nodeSlots struct {
+	limit int
+
+	mu    sync.Mutex
+	nodes map[string]chan struct{}
+}
+
+// newNodeSlots returns the per-node limit, or nil without one.
+func newNodeSlots(limit int) *nodeSlots {
+	if limit <= 0 {
+		return nil
+	}
+	return &nodeSlots{limit: limit, nodes: make(map[string]chan struct{})}
+}
+
+// acquire waits for a slot on the pod's node and returns the func that
+// releases it. Pods not yet scheduled aren't limited. It fails only if ctx
+// ends while waiting.
+func (n *nodeSlots) acquire(ctx context.Context, pod *v1.Pod) (func(), error) {
+	node := pod.Spec.NodeName
+	if n == nil || node == "" {
+		return func() {}, nil
+	}
+	n.mu.Lock()
+	sem, ok := n.nodes[node]
+	if !ok {
+		sem = make(chan struct{}, n.limit)
+		n.nodes[node] = sem
+	}
+	n.mu.Unlock()
+
+	release := func() { <-sem }
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	klog.V(1).Infof("Waiting to stress pod %s/%s, %d pods on node %s are already stressed", pod.Namespace, pod.Name, n.limit, node)
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a -max-stress-per-node slot on node %s for pod %s/%s: %w", node, pod.Namespace, pod.Name, ctx.Err())
+	}
+}
+
+// execContainer returns the container to exec into: the named one, or the
+// pod's first container when name is empty.
+func execContainer(pod *v1.Pod, name string) (string, error) {
+	if len(pod.Spec.Containers) == 0 {
+		return "", fmt.Errorf("pod %s/%s has no containers", pod.Namespace, pod.Name)
+	}
+	if name == "" {
+		return pod.Spec.Containers[0].Name, nil
+	}
+	names := make([]string, 0, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return name, nil
+		}
+		names = append(names, container.Name)
+	}
+	return "", fmt.Errorf("pod %s/%s has no container %q, want one of %s", pod.Namespace, pod.Name, name, strings.Join(names, ","))
+}
+
+// exec renders the command for the pod and runs it in the container in the
+// background, returning a func that closes the exec stream and waits for it.
+func (s *stressor) exec(ctx context.Context, pod *v1.Pod, container string) (func(), error) {
+	var command strings.Builder
+	data := stressCommandData{
+		Namespace:       pod.Namespace,
+		Pod:             pod.Name,
+		Container:       container,
+		DurationSeconds: int(s.duration.Round(time.Second).Seconds()),
+		MemMB:           s.memMB,
+	}
+	if err := s.command.Execute(&command, data); err != nil {
+		return nil, fmt.Errorf("rendering -stress-command for pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	req := s.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   []string{"sh", "-c", command.String()},
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(s.config, "POST", req.URL())
+	if err != nil {
+		return nil, fmt.Errorf("creating exec for pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	execCtx, cancel := s.timeouts.forExec(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := executor.StreamWithContext(execCtx, remotecommand.StreamOptions{Stdout: io.Discard, Stderr: io.Discard})
+		if err != nil && execCtx.Err() == nil {
+			klog.Errorf("Error running stress command in pod %s/%s container %s: %v", pod.Namespace, pod.Name, container, err)
+		} else if ctx.Err() == nil && execCtx.Err() == context.DeadlineExceeded {
+			klog.Warningf("Stress command in pod %s/%s container %s cut off by -exec-timeout %s", pod.Namespace, pod.Name, container, s.timeouts.exec)
+		}
+	}()
+	klog.V(2).Infof("Started stress command in pod %s/%s container %s", pod.Namespace, pod.Name, container)
+	return func() {
+		cancel()
+		wg.Wait()
+	}, nil
+}
@@ -0,0 +1,118 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// exportOptions configures -export-url.
+type exportOptions struct {
+	url       string
+	batchSize int
+	retries   int
+	// backoff is the wait before the first retry, doubled for each further
+	// one.
+	backoff time.Duration
+}
+
+// httpExporter POSTs results to an external collector as a JSON array of
+// the same objects -format json writes, batchSize rows per request. A batch
+// that still fails after the retries is logged and dropped so a collector
+// outage never aborts the run.
+type httpExporter struct {
+	ctx     context.Context
+	opts    exportOptions
+	layout  resultLayout
+	client  *http.Client
+	pending []*PodResult
+}
+
+// newHTTPExporter creates the exporter. Batches sent during the run are
+// posted and retried under ctx, the run's context.
+func newHTTPExporter(ctx context.Context, opts exportOptions, layout resultLayout) ResultWriter {
+	if opts.batchSize < 1 {
+		opts.batchSize = 1
+	}
+	return &httpExporter{ctx: ctx, opts: opts, layout: layout, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (e *httpExporter) WriteHeader() error {
+	return nil
+}
+
+func (e *httpExporter) WriteRow(result *PodResult) error {
+	e.pending = append(e.pending, result)
+	if len(e.pending) < e.opts.batchSize {
+		return nil
+	}
+	return e.flush(e.ctx)
+}
+
+// Close sends the last batch, with any the run's end interrupted. It
+// doesn't use the run's context so they still go out after an interrupt.
+func (e *httpExporter) Close() error {
+	return e.flush(context.Background())
+}
+
+// flush sends the pending results, retrying with backoff, and logs and drops
+// the batch once the retries run out. Once ctx is done the batch is kept
+// pending for Close.
+func (e *httpExporter) flush(ctx context.Context) error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	body.WriteString("[")
+	for i, result := range e.pending {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		writeJSONObject(&body, e.layout, result)
+	}
+	body.WriteString("]")
+	rows := len(e.pending)
+
+	backoff := e.opts.backoff
+	var err error
+	for attempt := 0; attempt <= e.opts.retries; attempt++ {
+		if attempt > 0 {
+			klog.Warningf("Error exporting %d results, retrying in %s: %v", rows, backoff, err)
+			if !sleepContext(ctx, backoff) {
+				return nil
+			}
+			backoff *= 2
+		}
+		if err = e.post(ctx, body.Bytes()); err == nil {
+			e.pending = nil
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+	e.pending = nil
+	klog.Errorf("Error exporting %d results to %s, dropping them: %v", rows, e.opts.url, err)
+	return nil
+}
+
+func (e *httpExporter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package stress
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPExporterInterruptedRetry(t *testing.T) {
+	var mu sync.Mutex
+	failing := true
+	var batches [][]map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("decoding batch: %v", err)
+		}
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	layout := resultLayout{{"pod", func(r *PodResult) string { return r.Pod }}}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := newHTTPExporter(ctx, exportOptions{url: server.URL, batchSize: 1, retries: 3, backoff: time.Hour}, layout)
+
+	// The run ends while the first batch waits to be retried
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if err := e.WriteRow(&PodResult{Pod: "web-1"}); err != nil {
+		t.Fatalf("WriteRow: %v", err)
+	}
+	if waited := time.Since(start); waited > 10*time.Second {
+		t.Fatalf("WriteRow waited %s for the retry after the run ended", waited)
+	}
+	if err := e.WriteRow(&PodResult{Pod: "web-2"}); err != nil {
+		t.Fatalf("WriteRow: %v", err)
+	}
+
+	// Close still sends both rows once the collector is back
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(batches) != 1 || len(batches[0]) != 2 || batches[0][0]["pod"] != "web-1" || batches[0][1]["pod"] != "web-2" {
+		t.Errorf("batches = %v, want one of web-1 and web-2", batches)
+	}
+}
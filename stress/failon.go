@@ -0,0 +1,106 @@
+package stress
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrFailOn is wrapped by the error Run returns when rows matched -fail-on.
+var ErrFailOn = errors.New("rows matched -fail-on")
+
+// failOnFields are the row values a -fail-on predicate can test: the
+// highest peak memory and CPU of a container over its limit, CPU over the
+// requests and the usage. Each is false for rows it doesn't apply to, e.g.
+// the ratios of pods without limits.
+var failOnFields = map[string]func(r *PodResult) (float64, bool){
+	"mem_ratio": func(r *PodResult) (float64, bool) {
+		percent, ok := r.PeakMemoryLimitPercent()
+		return percent / 100, ok
+	},
+	"cpu_ratio": func(r *PodResult) (float64, bool) {
+		percent, ok := r.CPULimitPercent()
+		return percent / 100, ok
+	},
+	"cpu_request_ratio": (*PodResult).CPUPerCore,
+	"cpu_milli": func(r *PodResult) (float64, bool) {
+		return float64(r.AvgCPUMilli), true
+	},
+	"memory_bytes": func(r *PodResult) (float64, bool) {
+		return float64(r.AvgMemoryBytes), true
+	},
+}
+
+// failOnOperators lists the comparisons, two-character ones first so that
+// ">=" isn't read as ">".
+var failOnOperators = []string{">=", "<=", "==", ">", "<"}
+
+// failOnPredicate is a parsed -fail-on predicate such as mem_ratio>0.95.
+type failOnPredicate struct {
+	text      string
+	field     func(r *PodResult) (float64, bool)
+	operator  string
+	threshold float64
+}
+
+// parseFailOn parses a -fail-on predicate: a field of failOnFields, a
+// comparison and a number.
+func parseFailOn(text string) (*failOnPredicate, error) {
+	predicate := strings.ReplaceAll(text, " ", "")
+	for _, operator := range failOnOperators {
+		name, value, found := strings.Cut(predicate, operator)
+		if !found {
+			continue
+		}
+		field, ok := failOnFields[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid -fail-on %q: unknown field %q, want one of %s", text, name, strings.Join(failOnFieldNames(), ", "))
+		}
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -fail-on %q: %q is not a number", text, value)
+		}
+		return &failOnPredicate{text: predicate, field: field, operator: operator, threshold: threshold}, nil
+	}
+	return nil, fmt.Errorf("invalid -fail-on %q, want a field, one of %s and a number, e.g. mem_ratio>0.95", text, strings.Join(failOnOperators, " "))
+}
+
+func failOnFieldNames() []string {
+	names := make([]string, 0, len(failOnFields))
+	for name := range failOnFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// match returns the row's value and whether it satisfies the predicate.
+func (p *failOnPredicate) match(r *PodResult) (float64, bool) {
+	value, ok := p.field(r)
+	if !ok {
+		return value, false
+	}
+	switch p.operator {
+	case ">=":
+		return value, value >= p.threshold
+	case "<=":
+		return value, value <= p.threshold
+	case "==":
+		return value, value == p.threshold
+	case ">":
+		return value, value > p.threshold
+	default:
+		return value, value < p.threshold
+	}
+}
+
+// FailOnMatch is a row that matched -fail-on, with the value tested. Name is
+// the pod, or the workload for deployment and grouped rows.
+type FailOnMatch struct {
+	Namespace string
+	Name      string
+	Container string
+	Value     float64
+}
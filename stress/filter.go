@@ -0,0 +1,71 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// filterTargetsWithMetrics drops targets that the metrics API has no
+// PodMetrics for, listing each target namespace once. It returns the kept
+// targets and how many were filtered out.
+func filterTargetsWithMetrics(ctx context.Context, metricsClient versioned.Interface, targets []Target, timeouts callTimeouts) ([]Target, int, error) {
+	withMetrics := make(map[string]map[string]bool)
+	for _, target := range targets {
+		if _, listed := withMetrics[target.Namespace]; listed {
+			continue
+		}
+		listCtx, cancel := timeouts.forList(ctx)
+		podMetricsList, err := metricsClient.MetricsV1beta1().PodMetricses(target.Namespace).List(listCtx, metav1.ListOptions{})
+		cancel()
+		if err != nil {
+			return nil, 0, fmt.Errorf("listing pod metrics in namespace %s: %w", target.Namespace, err)
+		}
+		names := make(map[string]bool, len(podMetricsList.Items))
+		for _, podMetrics := range podMetricsList.Items {
+			names[podMetrics.Name] = true
+		}
+		withMetrics[target.Namespace] = names
+	}
+
+	kept := make([]Target, 0, len(targets))
+	for _, target := range targets {
+		if withMetrics[target.Namespace][target.Name] {
+			kept = append(kept, target)
+		}
+	}
+	return kept, len(targets) - len(kept), nil
+}
+
+// selfPod returns the namespace and name of the pod the tool runs in, from
+// the POD_NAMESPACE and POD_NAME downward API variables, and false outside
+// a pod that sets them.
+func selfPod() (Target, bool) {
+	self := Target{Namespace: os.Getenv("POD_NAMESPACE"), Name: os.Getenv("POD_NAME")}
+	return self, self.Namespace != "" && self.Name != ""
+}
+
+// isSelf reports whether target is the tool's own pod, logging it as
+// skipped.
+func isSelf(target Target, self Target) bool {
+	if target.Namespace != self.Namespace || target.Name != self.Name {
+		return false
+	}
+	klog.Infof("Skipping pod: %s in namespace: %s, it is the pod this tool runs in (see -include-self)", target.Name, target.Namespace)
+	return true
+}
+
+// dropSelf removes the tool's own pod from targets.
+func dropSelf(targets []Target, self Target) []Target {
+	kept := targets[:0]
+	for _, target := range targets {
+		if !isSelf(target, self) {
+			kept = append(kept, target)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,116 @@
+package stress
+
+import "strings"
+
+// noLabelValue groups the results whose pods lack the -group-by-label label.
+const noLabelValue = "<none>"
+
+// ownerKindLabelGroup is the owner kind of -group-by-label rows.
+const ownerKindLabelGroup = "LabelGroup"
+
+// labelGroups collects the results of one measurement cycle by the value of
+// a pod label, keeping the groups in order of first appearance.
+type labelGroups struct {
+	key    string
+	order  []string
+	groups map[string][]*PodResult
+}
+
+func newLabelGroups(key string) *labelGroups {
+	return &labelGroups{key: key, groups: make(map[string][]*PodResult)}
+}
+
+// add files a result under its label value.
+func (g *labelGroups) add(result *PodResult) {
+	value, ok := result.Labels[g.key]
+	if !ok {
+		value = noLabelValue
+	}
+	if _, seen := g.groups[value]; !seen {
+		g.order = append(g.order, value)
+	}
+	g.groups[value] = append(g.groups[value], result)
+}
+
+// flush returns one merged result per label value and starts over.
+func (g *labelGroups) flush() []*PodResult {
+	merged := make([]*PodResult, 0, len(g.order))
+	for _, value := range g.order {
+		merged = append(merged, mergeResults(value, g.groups[value]))
+	}
+	g.order = nil
+	g.groups = make(map[string][]*PodResult)
+	return merged
+}
+
+// mergeResults aggregates results into one row named name. CPU and memory
+// are averaged weighted by samples, pooling them like a deployment row
+// does; containers and I/O are combined, and the namespaces, sources, images
+// and QoS classes that differ across the results are joined with "+", and
+// the selectors like a target matched by several.
+func mergeResults(name string, results []*PodResult) *PodResult {
+	merged := &PodResult{Owner: name, OwnerKind: ownerKindLabelGroup}
+	var namespaces, sources, images, imageIDs, classes, selectors, uids, trends []string
+	var cpuTotal, memoryTotal int64
+	var peaks []*PodResult
+	for _, result := range results {
+		if result.peak != nil {
+			peaks = append(peaks, result.peak)
+		}
+		namespaces = appendUnique(namespaces, result.Namespace)
+		sources = appendUnique(sources, result.Source)
+		images = appendUnique(images, result.Image)
+		imageIDs = appendUnique(imageIDs, result.ImageID)
+		classes = appendUnique(classes, result.QOSClass)
+		selectors = appendUnique(selectors, result.Selector)
+		uids = appendUnique(uids, result.UID)
+		trends = appendUnique(trends, result.Trend)
+		merged.Containers = append(merged.Containers, result.Containers...)
+		merged.Samples += result.Samples
+		if merged.Age == 0 || result.Age < merged.Age {
+			merged.Age = result.Age
+		}
+		if result.Status != "" {
+			merged.Status = result.Status
+		}
+		cpuTotal = addClamped(cpuTotal, mulClamped(result.AvgCPUMilli, int64(result.Samples)))
+		memoryTotal = addClamped(memoryTotal, mulClamped(result.AvgMemoryBytes, int64(result.Samples)))
+		if result.Extended != nil {
+			if merged.Extended == nil {
+				merged.Extended = &ExtendedStats{}
+			}
+			merged.Extended.NetworkRxBytes = addClamped(merged.Extended.NetworkRxBytes, result.Extended.NetworkRxBytes)
+			merged.Extended.NetworkTxBytes = addClamped(merged.Extended.NetworkTxBytes, result.Extended.NetworkTxBytes)
+			merged.Extended.EphemeralStorageBytes = addClamped(merged.Extended.EphemeralStorageBytes, result.Extended.EphemeralStorageBytes)
+		}
+	}
+	if merged.Samples > 0 {
+		merged.AvgCPUMilli = cpuTotal / int64(merged.Samples)
+		merged.AvgMemoryBytes = memoryTotal / int64(merged.Samples)
+	}
+	merged.Namespace = strings.Join(namespaces, "+")
+	merged.Source = strings.Join(sources, "+")
+	merged.Image = strings.Join(images, "+")
+	merged.ImageID = strings.Join(imageIDs, "+")
+	merged.QOSClass = strings.Join(classes, "+")
+	merged.Selector = strings.Join(selectors, selectorSeparator)
+	merged.UID = strings.Join(uids, "+")
+	merged.Trend = strings.Join(trends, "+")
+	if len(peaks) > 0 {
+		merged.peak = mergeResults(name, peaks)
+	}
+	return merged
+}
+
+// appendUnique appends value unless it is empty or already present.
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,76 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// hpaLookup finds the HorizontalPodAutoscaler scaling a workload. Each
+// namespace's HPAs are listed once and shared by all workers.
+type hpaLookup struct {
+	clientset kubernetes.Interface
+	timeouts  callTimeouts
+
+	mu          sync.Mutex
+	byNamespace map[string][]autoscalingv2.HorizontalPodAutoscaler
+}
+
+func newHPALookup(clientset kubernetes.Interface, timeouts callTimeouts) *hpaLookup {
+	return &hpaLookup{clientset: clientset, timeouts: timeouts, byNamespace: make(map[string][]autoscalingv2.HorizontalPodAutoscaler)}
+}
+
+// list returns the namespace's HPAs, listing them on first use.
+func (l *hpaLookup) list(ctx context.Context, namespace string) ([]autoscalingv2.HorizontalPodAutoscaler, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if hpas, ok := l.byNamespace[namespace]; ok {
+		return hpas, nil
+	}
+	ctx, cancel := l.timeouts.forList(ctx)
+	defer cancel()
+	hpaList, err := l.clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing horizontal pod autoscalers in namespace %s: %w", namespace, err)
+	}
+	l.byNamespace[namespace] = hpaList.Items
+	return hpaList.Items, nil
+}
+
+// status returns the HPA targeting the kind/name workload with its target
+// and current CPU utilization, or nil when there is none.
+func (l *hpaLookup) status(ctx context.Context, namespace, kind, name string) *HPAStatus {
+	hpas, err := l.list(ctx, namespace)
+	if err != nil {
+		klog.Errorf("Error getting HPA: %v", err)
+		return nil
+	}
+	for _, hpa := range hpas {
+		ref := hpa.Spec.ScaleTargetRef
+		if ref.Kind != kind || ref.Name != name {
+			continue
+		}
+
+		target, current := notAvailable, notAvailable
+		for _, metric := range hpa.Spec.Metrics {
+			if metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil &&
+				metric.Resource.Name == v1.ResourceCPU && metric.Resource.Target.AverageUtilization != nil {
+				target = fmt.Sprintf("%d%%", *metric.Resource.Target.AverageUtilization)
+			}
+		}
+		for _, metric := range hpa.Status.CurrentMetrics {
+			if metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil &&
+				metric.Resource.Name == v1.ResourceCPU && metric.Resource.Current.AverageUtilization != nil {
+				current = fmt.Sprintf("%d%%", *metric.Resource.Current.AverageUtilization)
+			}
+		}
+		return &HPAStatus{Name: hpa.Name, TargetCPU: target, CurrentCPU: current}
+	}
+	return nil
+}
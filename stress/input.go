@@ -0,0 +1,333 @@
+package stress
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog"
+)
+
+// Target is one input row: a pod, or a deployment in -deployments mode.
+type Target struct {
+	Namespace string
+	Name      string
+	// Container is the container -stress execs into, from an optional
+	// third pod,namespace,container field; empty for the -container default.
+	Container string
+	// Selector is the -selector, or selectors, that listed the target.
+	Selector string
+}
+
+// parseInputComment validates -input-comment, returning its character or 0
+// for none.
+func parseInputComment(value string) (rune, error) {
+	if value == "" {
+		return 0, nil
+	}
+	comment, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || comment == ',' || comment == '"' || comment == '\r' || comment == '\n' || comment == utf8.RuneError {
+		return 0, fmt.Errorf("-input-comment must be a single character other than a comma, quote or newline, got %q", value)
+	}
+	return comment, nil
+}
+
+// Input CSV columns, in the order parseTarget reads them.
+var (
+	podColumns        = []string{"pod", "namespace", "container"}
+	deploymentColumns = []string{"namespace", "deployment"}
+)
+
+// checkInputHeader validates the header row that -strict requires: every
+// column must be a known one, in parseTarget's order, naming at least the
+// first two, or just the pod with -discover-namespace. It returns the
+// records after the header.
+func checkInputHeader(records [][]string, deployments, discover bool) ([][]string, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("-strict input has no header row")
+	}
+	known := podColumns
+	if deployments {
+		known = deploymentColumns
+	}
+	header := records[0]
+	var unknown []string
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if i >= len(known) || column != known[i] {
+			unknown = append(unknown, fmt.Sprintf("%q (column %d)", column, i+1))
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unrecognized input columns %s, want a header of %s", strings.Join(unknown, ", "), strings.Join(known, ","))
+	}
+	required := 2
+	if discover && !deployments {
+		required = 1
+	}
+	if len(header) < required {
+		return nil, fmt.Errorf("input header %q is missing columns, want at least %s", strings.Join(header, ","), strings.Join(known[:required], ","))
+	}
+	return records[1:], nil
+}
+
+// parseTarget reads a Target from an input record, which is pod,namespace
+// or, for deployments, namespace,deployment. Pod rows may add a container
+// for -stress; other extra fields are ignored. Short records and empty
+// fields are errors rather than panics.
+func parseTarget(record []string, deployments bool) (Target, error) {
+	if len(record) < 2 {
+		return Target{}, fmt.Errorf("want 2 fields, got %d", len(record))
+	}
+	target := Target{Name: strings.TrimSpace(record[0]), Namespace: strings.TrimSpace(record[1])}
+	if deployments {
+		target = Target{Namespace: strings.TrimSpace(record[0]), Name: strings.TrimSpace(record[1])}
+	}
+	if target.Namespace == "" || target.Name == "" {
+		return Target{}, fmt.Errorf("empty namespace or name")
+	}
+	if !deployments && len(record) > 2 {
+		target.Container = strings.TrimSpace(record[2])
+	}
+	return target, nil
+}
+
+// parseTargets converts input records to Targets, logging and skipping the
+// malformed ones so a dirty input file can't crash a long run.
+func parseTargets(records [][]string, deployments bool) []Target {
+	targets := make([]Target, 0, len(records))
+	for i, record := range records {
+		target, err := parseTarget(record, deployments)
+		if err != nil {
+			klog.Warningf("Skipping input row %d %q: %v", i+1, strings.Join(record, ","), err)
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// readTargets loads the pods to stress from path. Files ending in .json,
+// .yaml or .yml are treated as kubectl-style PodList manifests; anything else
+// is read as a "pod,namespace" CSV file. Either may be gzip-compressed with a
+// further .gz extension, e.g. pods.csv.gz.
+func readTargets(path string, comment rune) ([][]string, error) {
+	if manifestInput(path) {
+		return readManifestTargets(path)
+	}
+	return readCSVTargets(path, comment)
+}
+
+// manifestInput reports whether path names a PodList manifest rather than a
+// CSV file, see readTargets.
+func manifestInput(path string) bool {
+	switch filepath.Ext(strings.TrimSuffix(strings.ToLower(path), ".gz")) {
+	case ".json", ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// readCSVTargets reads pod and namespace names from a CSV file.
+func readCSVTargets(path string, comment rune) ([][]string, error) {
+	podsFile, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening pods file: %w", err)
+	}
+	defer podsFile.Close()
+	return parseCSVTargets(podsFile, comment)
+}
+
+// openInput opens path for reading, decompressing it if it ends in .gz.
+func openInput(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".gz") {
+		return file, nil
+	}
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading gzip header of %s: %w", path, err)
+	}
+	return &gzipFile{Reader: decompressed, file: file}, nil
+}
+
+// gzipFile closes both the decompressor and the file underneath it.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if closeErr := g.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newTargetsCSV returns a reader of "pod,namespace" records, which may vary
+// in length. Lines starting with comment are skipped; 0 disables comments.
+func newTargetsCSV(r io.Reader, comment rune) *csv.Reader {
+	podsCSV := csv.NewReader(r)
+	podsCSV.FieldsPerRecord = -1 // Allow variable number of fields
+	podsCSV.Comment = comment
+	return podsCSV
+}
+
+// parseCSVTargets parses "pod,namespace" records.
+func parseCSVTargets(r io.Reader, comment rune) ([][]string, error) {
+	podsCSV := newTargetsCSV(r, comment)
+	podsData, err := podsCSV.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading pods CSV: %w", err)
+	}
+	return podsData, nil
+}
+
+// streamCSVTargets reads the "pod,namespace" CSV podsFile, opened from
+// path, one record at a time, sending the targets keep accepts as they are
+// parsed so that measuring starts before a large file has been read, and
+// closes it at the end. Malformed rows are logged and skipped like
+// parseTargets does, and a read error ends the stream early with the
+// targets read so far. Each sent target is first appended to *kept, which
+// is complete once the channel closes, for re-measuring in -watch mode. The
+// stream also ends once ctx is done.
+func streamCSVTargets(ctx context.Context, podsFile io.ReadCloser, path string, comment rune, deployments bool, keep func(Target) bool, kept *[]Target) <-chan Target {
+	out := make(chan Target)
+	go func() {
+		defer close(out)
+		defer podsFile.Close()
+		podsCSV := newTargetsCSV(podsFile, comment)
+		podsCSV.ReuseRecord = true
+		read := 0
+		for row := 1; ; row++ {
+			record, err := podsCSV.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				klog.Errorf("Error reading pods CSV, measuring the %d targets read so far: %v", len(*kept), err)
+				break
+			}
+			target, err := parseTarget(record, deployments)
+			if err != nil {
+				klog.Warningf("Skipping input row %d %q: %v", row, strings.Join(record, ","), err)
+				continue
+			}
+			read++
+			if !keep(target) {
+				continue
+			}
+			*kept = append(*kept, target)
+			select {
+			case out <- target:
+			case <-ctx.Done():
+				return
+			}
+		}
+		klog.V(1).Infof("Read %d targets from %s, kept %d", read, path, len(*kept))
+	}()
+	return out
+}
+
+// readManifestTargets decodes the output of `kubectl get pods -o json|yaml`
+// and returns one "pod,namespace" record per item. kubectl wraps its output
+// in a generic v1.List, so both that and a typed v1.PodList are accepted.
+func readManifestTargets(path string) ([][]string, error) {
+	manifest, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pods manifest: %w", err)
+	}
+	defer manifest.Close()
+	data, err := io.ReadAll(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("reading pods manifest: %w", err)
+	}
+
+	decoder := scheme.Codecs.UniversalDeserializer()
+	obj, _, err := decoder.Decode(data, nil, &v1.PodList{})
+	if err != nil {
+		return nil, fmt.Errorf("decoding pods manifest: %w", err)
+	}
+
+	var pods []v1.Pod
+	switch list := obj.(type) {
+	case *v1.PodList:
+		pods = list.Items
+	case *v1.List:
+		for i, item := range list.Items {
+			pod := &v1.Pod{}
+			if _, _, err := decoder.Decode(item.Raw, nil, pod); err != nil {
+				return nil, fmt.Errorf("decoding pods manifest item %d: %w", i, err)
+			}
+			pods = append(pods, *pod)
+		}
+	case *v1.Pod:
+		pods = []v1.Pod{*list}
+	default:
+		return nil, fmt.Errorf("pods manifest has unsupported kind %q", obj.GetObjectKind().GroupVersionKind().Kind)
+	}
+
+	podsData := make([][]string, 0, len(pods))
+	for _, pod := range pods {
+		podsData = append(podsData, []string{pod.Name, pod.Namespace})
+	}
+	return podsData, nil
+}
+
+// readConfigMapTargets reads "pod,namespace" CSV content from a ConfigMap
+// key, given as namespace/name/key.
+func readConfigMapTargets(ctx context.Context, clientset kubernetes.Interface, ref string, comment rune) ([][]string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid configmap reference %q, want namespace/name/key", ref)
+	}
+	namespace, name, key := parts[0], parts[1], parts[2]
+
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting configmap %s/%s: %w", namespace, name, err)
+	}
+	content, ok := configMap.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("configmap %s/%s has no key %q", namespace, name, key)
+	}
+	return parseCSVTargets(strings.NewReader(content), comment)
+}
+
+// readNamespaceMap reads "namespace,friendly name" CSV records used to
+// relabel namespaces in the output.
+func readNamespaceMap(path string) (map[string]string, error) {
+	mapFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening namespace map: %w", err)
+	}
+	defer mapFile.Close()
+
+	mapCSV := csv.NewReader(mapFile)
+	mapCSV.FieldsPerRecord = 2
+	records, err := mapCSV.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading namespace map: %w", err)
+	}
+	namespaces := make(map[string]string, len(records))
+	for _, record := range records {
+		namespaces[strings.TrimSpace(record[0])] = strings.TrimSpace(record[1])
+	}
+	return namespaces, nil
+}
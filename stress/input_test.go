@@ -0,0 +1,86 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fieldSeparator splits a fuzzed string into the fields of one record, since
+// fuzz arguments can't be a []string.
+const fieldSeparator = "\x00"
+
+// checkTarget fails the test unless target is one parseTarget may return.
+func checkTarget(t *testing.T, target Target) {
+	t.Helper()
+	if target.Name == "" || target.Namespace == "" {
+		t.Errorf("target %+v has an empty name or namespace", target)
+	}
+	for _, field := range []string{target.Name, target.Namespace, target.Container} {
+		if field != strings.TrimSpace(field) {
+			t.Errorf("target %+v has an untrimmed field %q", target, field)
+		}
+	}
+}
+
+func FuzzParseTarget(f *testing.F) {
+	for _, seed := range []string{
+		"web-1",
+		"",
+		"   ",
+		" \t" + fieldSeparator + " ",
+		"\ufeffweb-1" + fieldSeparator + "default",
+		"web-1" + fieldSeparator + "default" + fieldSeparator + "app",
+		"web-1" + fieldSeparator + "",
+		fieldSeparator + fieldSeparator,
+	} {
+		f.Add(seed, false)
+		f.Add(seed, true)
+	}
+	f.Fuzz(func(t *testing.T, fields string, deployments bool) {
+		record := strings.Split(fields, fieldSeparator)
+		target, err := parseTarget(record, deployments)
+		if err != nil {
+			return
+		}
+		checkTarget(t, target)
+		if deployments && target.Container != "" {
+			t.Errorf("deployment target %+v has a container", target)
+		}
+	})
+}
+
+func FuzzStreamCSVTargets(f *testing.F) {
+	for _, seed := range []string{
+		"web-1\n",
+		"",
+		"   \n\t\n",
+		"\ufeffweb-1,default\n",
+		"web-1,default,app\nweb-2,\n,default\n",
+		"\"unterminated,default\n",
+		"# comment\nweb-1,default\n",
+	} {
+		f.Add([]byte(seed), false)
+		f.Add([]byte(seed), true)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, deployments bool) {
+		var kept []Target
+		keep := func(Target) bool { return true }
+		feed := streamCSVTargets(context.Background(), io.NopCloser(bytes.NewReader(data)), "fuzz.csv", '#', deployments, keep, &kept)
+		var sent []Target
+		for target := range feed {
+			checkTarget(t, target)
+			sent = append(sent, target)
+		}
+		if len(sent) != len(kept) {
+			t.Fatalf("sent %d targets, kept %d", len(sent), len(kept))
+		}
+		for i := range sent {
+			if sent[i] != kept[i] {
+				t.Errorf("sent %+v, kept %+v", sent[i], kept[i])
+			}
+		}
+	})
+}
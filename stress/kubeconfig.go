@@ -0,0 +1,81 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+)
+
+// kubeconfigFetchTimeout bounds fetching a -kubeconfig URL.
+const kubeconfigFetchTimeout = 30 * time.Second
+
+// clientConfig builds the client config of cfg's kubeconfig, loaded with
+// kubectl's precedence or fetched from a -kubeconfig URL, with the TLS and
+// impersonation flags applied.
+func clientConfig(ctx context.Context, cfg Config) (*rest.Config, error) {
+	var config *rest.Config
+	var err error
+	if remoteKubeconfig(cfg.Kubeconfig) {
+		config, err = fetchKubeconfig(ctx, cfg.Kubeconfig)
+	} else {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = cfg.Kubeconfig
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("building kubeconfig: %w", err)
+	}
+
+	// Apply TLS overrides; client-go rejects a CA alongside insecure mode
+	if cfg.CertificateAuthority != "" {
+		config.TLSClientConfig.CAFile = cfg.CertificateAuthority
+		config.TLSClientConfig.CAData = nil
+	}
+	if cfg.As != "" || len(cfg.AsGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{UserName: cfg.As, Groups: cfg.AsGroups}
+		klog.Infof("Impersonating user %q, groups %v", cfg.As, cfg.AsGroups)
+	}
+	if cfg.InsecureSkipTLSVerify {
+		config.TLSClientConfig.Insecure = true
+		config.TLSClientConfig.CAFile = ""
+		config.TLSClientConfig.CAData = nil
+	}
+	return config, nil
+}
+
+// remoteKubeconfig reports whether -kubeconfig is an http(s) URL rather
+// than a path.
+func remoteKubeconfig(kubeconfig string) bool {
+	return strings.HasPrefix(kubeconfig, "https://") || strings.HasPrefix(kubeconfig, "http://")
+}
+
+// fetchKubeconfig downloads a kubeconfig, e.g. from a secret manager, and
+// builds its current context's client config.
+func fetchKubeconfig(ctx context.Context, url string) (*rest.Config, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubeconfigFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: server returned %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	return clientcmd.RESTConfigFromKubeConfig(data)
+}
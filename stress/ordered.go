@@ -0,0 +1,212 @@
+package stress
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// orderStallWarning is how long ordered emission may wait on one slow target
+// while later results pile up before it is logged.
+const orderStallWarning = 30 * time.Second
+
+// orderedJob is the target dispatched as the index-th of a run.
+type orderedJob struct {
+	index  int
+	target Target
+}
+
+// orderedResult is a worker's output for the target at index. Targets that
+// failed have a nil result and produce no row.
+type orderedResult struct {
+	index  int
+	result *PodResult
+}
+
+// runOrdered runs work for each target received from targets on up to
+// workers goroutines and passes the results to emit in the order received.
+// Finished results wait in a reorder buffer until every earlier target is
+// done; at most bufferSize targets are in flight or buffered at once, so a
+// slow target holds back dispatch rather than letting the buffer grow
+// without bound. No new targets are started once ctx is done.
+func runOrdered(ctx context.Context, targets <-chan Target, workers, bufferSize int, work func(Target) *PodResult, emit func(*PodResult), describe func(Target) string) {
+	if workers < 1 {
+		workers = 1
+	}
+	if bufferSize < workers {
+		bufferSize = workers
+	}
+
+	slots := make(chan struct{}, bufferSize)
+	jobs := make(chan orderedJob)
+	results := make(chan orderedResult)
+
+	// dispatched holds the targets started but not yet emitted, for the
+	// stall warning.
+	var mu sync.Mutex
+	dispatched := make(map[int]Target)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- orderedResult{index: job.index, result: work(job.target)}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := 0; ; i++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			var target Target
+			var open bool
+			select {
+			case target, open = <-targets:
+			case <-ctx.Done():
+			}
+			if !open || ctx.Err() != nil {
+				return
+			}
+			mu.Lock()
+			dispatched[i] = target
+			mu.Unlock()
+			jobs <- orderedJob{index: i, target: target}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Only a prefix of the targets is ever dispatched, so once every worker
+	// has finished the buffer drains completely.
+	pending := make(map[int]orderedResult)
+	next := 0
+	lastEmit := time.Now()
+	stall := time.NewTicker(orderStallWarning)
+	defer stall.Stop()
+
+	for {
+		select {
+		case result, open := <-results:
+			if !open {
+				return
+			}
+			pending[result.index] = result
+			for {
+				ready, found := pending[next]
+				if !found {
+					break
+				}
+				delete(pending, next)
+				mu.Lock()
+				delete(dispatched, next)
+				mu.Unlock()
+				if ready.result != nil {
+					emit(ready.result)
+				}
+				<-slots
+				next++
+				lastEmit = time.Now()
+			}
+		case <-stall.C:
+			if len(pending) > 0 && time.Since(lastEmit) >= orderStallWarning {
+				mu.Lock()
+				waiting := dispatched[next]
+				mu.Unlock()
+				klog.Warningf("Ordered output waiting on %s for %s, %d later results buffered", describe(waiting), time.Since(lastEmit).Round(time.Second), len(pending))
+			}
+		}
+	}
+}
+
+// sendTargets returns a channel that yields targets in order, closed after
+// the last one or once ctx is done.
+func sendTargets(ctx context.Context, targets []Target) <-chan Target {
+	out := make(chan Target)
+	go func() {
+		defer close(out)
+		for _, target := range targets {
+			select {
+			case out <- target:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// NamespaceTiming is how long one namespace took under -parallel-namespaces.
+type NamespaceTiming struct {
+	Namespace string
+	Targets   int
+	Duration  time.Duration
+}
+
+// runByNamespace measures the targets of up to parallel namespaces at once,
+// each namespace with its own runOrdered of workers goroutines. A
+// namespace's results are held until it finishes and then emitted
+// together, in input order, so the output stays grouped by namespace while
+// emit is only ever called by one goroutine at a time. It returns the
+// timing of each namespace, in the order they were first seen.
+func runByNamespace(ctx context.Context, targets []Target, parallel, workers, bufferSize int, work func(Target) *PodResult, emit func(*PodResult), describe func(Target) string) []NamespaceTiming {
+	var order []string
+	byNamespace := make(map[string][]Target)
+	for _, target := range targets {
+		if _, seen := byNamespace[target.Namespace]; !seen {
+			order = append(order, target.Namespace)
+		}
+		byNamespace[target.Namespace] = append(byNamespace[target.Namespace], target)
+	}
+
+	timings := make([]NamespaceTiming, len(order))
+	sem := make(chan struct{}, parallel)
+	var emitMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, namespace := range order {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, namespace string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			start := time.Now()
+			var results []*PodResult
+			collect := func(result *PodResult) { results = append(results, result) }
+			runOrdered(ctx, sendTargets(ctx, byNamespace[namespace]), workers, bufferSize, work, collect, describe)
+			timings[i] = NamespaceTiming{Namespace: namespace, Targets: len(byNamespace[namespace]), Duration: time.Since(start)}
+
+			emitMu.Lock()
+			defer emitMu.Unlock()
+			for _, result := range results {
+				emit(result)
+			}
+		}(i, namespace)
+	}
+	wg.Wait()
+
+	// Namespaces never started have no timing
+	started := timings[:0]
+	for _, timing := range timings {
+		if timing.Namespace != "" {
+			started = append(started, timing)
+		}
+	}
+	return started
+}
@@ -0,0 +1,182 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"k8s.io/klog"
+)
+
+// otlpMetricsPath is the OTLP/HTTP path used when -otlp-endpoint has none.
+const otlpMetricsPath = "/v1/metrics"
+
+// otlpShutdownTimeout bounds the final export when the run ends.
+const otlpShutdownTimeout = 30 * time.Second
+
+// otlpWriter records each row's CPU and memory as OTLP gauges, exported to
+// the -otlp-endpoint collector by a periodic reader and once more on Close.
+// The gauges report the latest reading of every pod, or container with
+// -per-container, of this cycle or the last. Like -export-url, a failed
+// export is logged and never aborts the run.
+type otlpWriter struct {
+	provider *sdkmetric.MeterProvider
+
+	mu       sync.Mutex
+	readings map[attribute.Distinct]otlpReading
+	cycle    int
+}
+
+// otlpReading is the latest usage of one series, from cycle.
+type otlpReading struct {
+	attrs       attribute.Set
+	cpuCores    float64
+	memoryBytes int64
+	cycle       int
+}
+
+// loggingExporter logs failed exports, rather than leaving them to the
+// process-wide OpenTelemetry error handler, which belongs to the program
+// embedding the package.
+type loggingExporter struct {
+	sdkmetric.Exporter
+}
+
+func (e loggingExporter) Export(ctx context.Context, metrics *metricdata.ResourceMetrics) error {
+	if err := e.Exporter.Export(ctx, metrics); err != nil {
+		klog.Warningf("Error exporting OTLP metrics: %v", err)
+	}
+	return nil
+}
+
+// parseOTLPEndpoint checks an -otlp-endpoint URL, adding the standard
+// metrics path when it has none.
+func parseOTLPEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid -otlp-endpoint: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid -otlp-endpoint %q, want an http or https URL such as http://otel-collector:4318", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpMetricsPath
+	}
+	return u.String(), nil
+}
+
+// newOTLPWriter starts the meter provider exporting to endpoint, a URL
+// checked by parseOTLPEndpoint, with runID as the service instance.
+func newOTLPWriter(ctx context.Context, endpoint, runID string) (*otlpWriter, error) {
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "stresstest"),
+		attribute.String("service.instance.id", runID),
+	)
+	w := &otlpWriter{readings: make(map[attribute.Distinct]otlpReading)}
+	w.provider = sdkmetric.NewMeterProvider(sdkmetric.WithResource(res), sdkmetric.WithReader(sdkmetric.NewPeriodicReader(loggingExporter{exporter})))
+	if err := w.registerGauges(); err != nil {
+		w.provider.Shutdown(ctx)
+		return nil, err
+	}
+	return w, nil
+}
+
+// registerGauges creates the CPU and memory gauges, observing the readings
+// at each collection.
+func (w *otlpWriter) registerGauges() error {
+	meter := w.provider.Meter("finalproject/stresstest")
+	cpu, err := meter.Float64ObservableGauge("k8s.pod.cpu.usage", metric.WithUnit("{cpu}"),
+		metric.WithDescription("Average CPU usage over the samples, in cores"))
+	if err != nil {
+		return fmt.Errorf("creating OTLP cpu gauge: %w", err)
+	}
+	memory, err := meter.Int64ObservableGauge("k8s.pod.memory.usage", metric.WithUnit("By"),
+		metric.WithDescription("Average memory working set over the samples"))
+	if err != nil {
+		return fmt.Errorf("creating OTLP memory gauge: %w", err)
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for _, reading := range w.readings {
+			observer.ObserveFloat64(cpu, reading.cpuCores, metric.WithAttributeSet(reading.attrs))
+			observer.ObserveInt64(memory, reading.memoryBytes, metric.WithAttributeSet(reading.attrs))
+		}
+		return nil
+	}, cpu, memory)
+	if err != nil {
+		return fmt.Errorf("registering OTLP gauges: %w", err)
+	}
+	return nil
+}
+
+func (w *otlpWriter) WriteHeader() error {
+	return nil
+}
+
+func (w *otlpWriter) WriteRow(result *PodResult) error {
+	attrs := otlpAttributes(result)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.readings[attrs.Equivalent()] = otlpReading{
+		attrs:       attrs,
+		cpuCores:    float64(result.AvgCPUMilli) / 1000,
+		memoryBytes: result.AvgMemoryBytes,
+		cycle:       w.cycle,
+	}
+	return nil
+}
+
+// endCycle drops the series that weren't written this -watch cycle, such as
+// deleted pods, so they stop being exported once the next cycle starts.
+func (w *otlpWriter) endCycle() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, reading := range w.readings {
+		if reading.cycle != w.cycle {
+			delete(w.readings, key)
+		}
+	}
+	w.cycle++
+}
+
+// Close exports the last readings and stops the provider. It doesn't use
+// the run's context so the final export still goes out after an interrupt.
+func (w *otlpWriter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+	defer cancel()
+	if err := w.provider.Shutdown(ctx); err != nil {
+		klog.Warningf("Error exporting OTLP metrics: %v", err)
+	}
+	return nil
+}
+
+// otlpAttributes names a row's series with the Kubernetes semantic
+// conventions: the namespace, the pod and container when set, and the
+// owner as e.g. k8s.deployment.name.
+func otlpAttributes(result *PodResult) attribute.Set {
+	attrs := []attribute.KeyValue{attribute.String("k8s.namespace.name", result.Namespace)}
+	if result.Pod != "" {
+		attrs = append(attrs, attribute.String("k8s.pod.name", result.Pod))
+	}
+	if result.Container != "" {
+		attrs = append(attrs, attribute.String("k8s.container.name", result.Container))
+	}
+	if result.Owner != "" && result.OwnerKind != "" {
+		attrs = append(attrs, attribute.String("k8s."+strings.ToLower(result.OwnerKind)+".name", result.Owner))
+	}
+	return attribute.NewSet(attrs...)
+}
@@ -0,0 +1,244 @@
+package stress
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// defaultOutputPath is where metrics are written when neither -output nor
+// -output-dir is given.
+const defaultOutputPath = "metrics.csv"
+
+// notAvailable is written in place of a value that could not be measured or
+// looked up.
+const notAvailable = "n/a"
+
+// Output formats accepted by -format.
+const (
+	formatCSV      = "csv"
+	formatJSON     = "json"
+	formatMarkdown = "markdown"
+)
+
+// formatExt returns the file extension used for a -format in -output-dir.
+func formatExt(format string) string {
+	if format == formatMarkdown {
+		return "md"
+	}
+	return format
+}
+
+// parseFormats splits a comma-separated -format, e.g. csv,json. The first
+// format is written to the metrics file and each other one next to it, see
+// formatOutputPath.
+func parseFormats(value string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(value, ",") {
+		format = strings.TrimSpace(format)
+		if _, ok := resultWriters[format]; !ok {
+			return nil, fmt.Errorf("unknown format %q, want %s, %s or %s", format, formatCSV, formatJSON, formatMarkdown)
+		}
+		for _, seen := range formats {
+			if seen == format {
+				return nil, fmt.Errorf("format %q is given twice", format)
+			}
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// formatOutputPath returns the file a further -format is written to: the
+// metrics file with its extension replaced, e.g. metrics.json.
+func formatOutputPath(metricsPath, format string) string {
+	return strings.TrimSuffix(metricsPath, filepath.Ext(metricsPath)) + "." + formatExt(format)
+}
+
+// namespaceOutputPath returns the -split-by-namespace file of namespace in
+// outputDir, e.g. team-a.csv.
+func namespaceOutputPath(outputDir, namespace, ext string) string {
+	if namespace == "" {
+		namespace = "no-namespace"
+	}
+	return filepath.Join(outputDir, namespace+"."+ext)
+}
+
+// checkNoClobber fails if any of the output files already exists, so a
+// rerun can't overwrite a previous run's metrics.
+func checkNoClobber(paths []string) error {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists and -no-clobber is set; remove it, pick another -output or use -output-dir", path)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("checking %s for -no-clobber: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// resolveOutputPath picks the metrics file for this run. With an output
+// directory, the file is named after the run's start time so scheduled runs
+// don't overwrite each other; the directory is created if needed.
+func resolveOutputPath(output, outputDir, ext string, start time.Time) (string, error) {
+	if outputDir == "" {
+		return output, nil
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return "", fmt.Errorf("creating output directory: %w", err)
+	}
+	name := fmt.Sprintf("metrics-%s.%s", start.UTC().Format(time.RFC3339), ext)
+	return filepath.Join(outputDir, name), nil
+}
+
+// memoryUnits maps the -mem-unit values to their size in bytes.
+var memoryUnits = map[string]float64{
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+// quantityFormat controls how CPU and memory figures are printed.
+type quantityFormat struct {
+	precision int
+	memUnit   string
+}
+
+func newQuantityFormat(precision int, memUnit string) (quantityFormat, error) {
+	if precision < 0 {
+		return quantityFormat{}, fmt.Errorf("precision must not be negative")
+	}
+	if _, ok := memoryUnits[memUnit]; !ok {
+		return quantityFormat{}, fmt.Errorf("unknown memory unit %q, want Ki, Mi, Gi or Ti", memUnit)
+	}
+	return quantityFormat{precision: precision, memUnit: memUnit}, nil
+}
+
+// cpu prints whole millicores at precision 0, matching the historic output,
+// and fractional cores otherwise (e.g. "0.05" at precision 2).
+func (f quantityFormat) cpu(milli int64) string {
+	if f.precision == 0 {
+		return fmt.Sprintf("%d"+"m", milli)
+	}
+	return fmt.Sprintf("%.*f", f.precision, float64(milli)/1000)
+}
+
+// memory prints bytes in the configured unit, e.g. "128Mi" or "0.05Gi".
+func (f quantityFormat) memory(bytes int64) string {
+	return fmt.Sprintf("%.*f%s", f.precision, float64(bytes)/memoryUnits[f.memUnit], f.memUnit)
+}
+
+// tableOptions controls which rows and columns reach the output, and in what
+// order.
+type tableOptions struct {
+	columns []int // indexes into the layout, nil for all columns
+	sortBy  int   // layout index to sort by, -1 to keep input order
+	desc    bool
+	limit   int // 0 for no limit
+}
+
+// parseTableOptions resolves the -columns and -sort flags against the
+// available column names. A leading "-" on the sort column sorts descending.
+func parseTableOptions(format, columns, sortBy string, limit int, available []string) (tableOptions, error) {
+	opts := tableOptions{sortBy: -1, limit: limit}
+	if _, ok := resultWriters[format]; !ok {
+		return opts, fmt.Errorf("unknown format %q, want %s, %s or %s", format, formatCSV, formatJSON, formatMarkdown)
+	}
+	if limit < 0 {
+		return opts, fmt.Errorf("limit must not be negative")
+	}
+
+	index := func(name string) (int, error) {
+		for i, column := range available {
+			if column == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown column %q, want one of %s", name, strings.Join(available, ","))
+	}
+	if columns != "" {
+		for _, name := range strings.Split(columns, ",") {
+			i, err := index(strings.TrimSpace(name))
+			if err != nil {
+				return opts, err
+			}
+			opts.columns = append(opts.columns, i)
+		}
+	}
+	if sortBy != "" {
+		opts.desc = strings.HasPrefix(sortBy, "-")
+		i, err := index(strings.TrimPrefix(sortBy, "-"))
+		if err != nil {
+			return opts, err
+		}
+		opts.sortBy = i
+	}
+	return opts, nil
+}
+
+// buffered reports whether rows must be collected before anything is
+// written: sorting and limiting need the full result.
+func (o tableOptions) buffered() bool {
+	return o.sortBy >= 0 || o.limit > 0
+}
+
+// arrange sorts and truncates the full set of results.
+func (o tableOptions) arrange(layout resultLayout, results []*PodResult) []*PodResult {
+	if o.sortBy >= 0 {
+		key := layout[o.sortBy].value
+		sort.SliceStable(results, func(i, j int) bool {
+			a, b := key(results[i]), key(results[j])
+			if o.desc {
+				a, b = b, a
+			}
+			if cellLess(a, b) {
+				return true
+			}
+			if cellLess(b, a) {
+				return false
+			}
+			return resultLess(results[i], results[j])
+		})
+	}
+	if o.limit > 0 && len(results) > o.limit {
+		results = results[:o.limit]
+	}
+	return results
+}
+
+// resultLess breaks a sort tie by namespace, then pod or workload name,
+// then container, so that rows with equal keys come out the same way
+// every run, whatever order they finished in.
+func resultLess(a, b *PodResult) bool {
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	aName, bName := a.Pod, b.Pod
+	if aName == "" && bName == "" {
+		aName, bName = a.Owner, b.Owner
+	}
+	if aName != bName {
+		return aName < bName
+	}
+	return a.Container < b.Container
+}
+
+// cellLess orders cells numerically when both parse as quantities such as
+// "250m" or "128Mi", or both are percentages, and lexically otherwise.
+func cellLess(a, b string) bool {
+	if strings.HasSuffix(a, "%") && strings.HasSuffix(b, "%") {
+		a, b = strings.TrimSuffix(a, "%"), strings.TrimSuffix(b, "%")
+	}
+	qa, errA := resource.ParseQuantity(a)
+	qb, errB := resource.ParseQuantity(b)
+	if errA == nil && errB == nil {
+		return qa.Cmp(qb) < 0
+	}
+	return a < b
+}
@@ -0,0 +1,71 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// ownerKindUnknown is reported when the owner chain could not be read,
+// typically because the caller lacks RBAC for replicasets or deployments.
+const ownerKindUnknown = "unknown"
+
+// ownerKindSkipped is reported with -resolve-owner=false, when the pod name
+// stands in for the owner without any lookups.
+const ownerKindSkipped = "skipped"
+
+// resolveOwner walks the pod's controller references up to the top-level
+// workload (Pod -> ReplicaSet -> Deployment) and returns its name and kind.
+// Resolution is best-effort: if the apps API cannot be read, the pod name is
+// returned with kind "unknown" so the pod can still be measured.
+func resolveOwner(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod) (string, string) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return pod.Name, "Pod"
+	}
+	if ref.Kind != "ReplicaSet" {
+		return ref.Name, ref.Kind
+	}
+
+	rs, err := clientset.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsForbidden(err) {
+			klog.V(2).Infof("Not allowed to read replicaset %s/%s, using pod name as owner: %v", pod.Namespace, ref.Name, err)
+		} else {
+			klog.Warningf("Error getting replicaset %s/%s, using pod name as owner: %v", pod.Namespace, ref.Name, err)
+		}
+		return pod.Name, ownerKindUnknown
+	}
+
+	rsRef := metav1.GetControllerOf(rs)
+	if rsRef == nil {
+		return rs.Name, "ReplicaSet"
+	}
+	return rsRef.Name, rsRef.Kind
+}
+
+// deploymentPods returns the current pods matched by a deployment's selector.
+func deploymentPods(ctx context.Context, clientset kubernetes.Interface, namespace, name string, timeouts callTimeouts) ([]v1.Pod, error) {
+	getCtx, cancel := timeouts.forGet(ctx)
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(getCtx, name, metav1.GetOptions{})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("getting deployment %s/%s: %w", namespace, name, err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("parsing selector of deployment %s/%s: %w", namespace, name, err)
+	}
+	listCtx, cancel := timeouts.forList(ctx)
+	defer cancel()
+	podList, err := clientset.CoreV1().Pods(namespace).List(listCtx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods of deployment %s/%s: %w", namespace, name, err)
+	}
+	return podList.Items, nil
+}
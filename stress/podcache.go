@@ -0,0 +1,134 @@
+package stress
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+// podCacheSyncTimeout bounds the initial list of a namespace's pod cache
+// when -list-timeout doesn't, since an informer denied the watch retries
+// without ever failing.
+const podCacheSyncTimeout = 30 * time.Second
+
+// podLookup gets pods by name, either with a Get each or, for -watch runs
+// that read the same pods every cycle, from a shared informer cache kept
+// in sync by a watch. A one-shot run gets each pod only a few times, not
+// worth an informer's initial list.
+type podLookup struct {
+	clientset kubernetes.Interface
+	timeouts  callTimeouts
+
+	// cached serves Gets from an informer per namespace, started on the
+	// namespace's first Get and stopped by close. The initial list runs
+	// under runCtx, not the Get's, so a pod cut short by -pod-timeout
+	// doesn't leave its namespace uncached for the rest of the run.
+	cached     bool
+	runCtx     context.Context
+	mu         sync.Mutex
+	namespaces map[string]*namespacePods
+}
+
+// namespacePods is the informer cache of one namespace's pods. A nil lister
+// means the informer couldn't sync and was stopped, so Gets go to the API
+// server.
+type namespacePods struct {
+	once    sync.Once
+	factory informers.SharedInformerFactory
+	stopCh  chan struct{}
+	lister  corelisters.PodNamespaceLister
+}
+
+func newPodLookup(runCtx context.Context, clientset kubernetes.Interface, timeouts callTimeouts, cached bool) *podLookup {
+	pods := &podLookup{clientset: clientset, timeouts: timeouts, cached: cached, runCtx: runCtx}
+	if cached {
+		pods.namespaces = make(map[string]*namespacePods)
+	}
+	return pods
+}
+
+// get returns the named pod. A pod missing from the cache, e.g. one created
+// since the last watch event, is looked up with a Get, so a NotFound
+// always comes from the API server.
+func (p *podLookup) get(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	if p.cached {
+		if lister := p.lister(namespace); lister != nil {
+			pod, err := lister.Get(name)
+			if err == nil {
+				return pod.DeepCopy(), nil
+			}
+			if !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+		}
+	}
+	getCtx, cancel := p.timeouts.forGet(ctx)
+	defer cancel()
+	return p.clientset.CoreV1().Pods(namespace).Get(getCtx, name, metav1.GetOptions{})
+}
+
+// lister returns the namespace's cache, starting its informer and waiting
+// for the initial list on first use. It is nil if the list didn't finish,
+// e.g. without RBAC to watch pods, and then stays nil for the run.
+func (p *podLookup) lister(namespace string) corelisters.PodNamespaceLister {
+	p.mu.Lock()
+	pods, ok := p.namespaces[namespace]
+	if !ok {
+		pods = &namespacePods{}
+		p.namespaces[namespace] = pods
+	}
+	p.mu.Unlock()
+
+	pods.once.Do(func() {
+		pods.factory = informers.NewSharedInformerFactoryWithOptions(p.clientset, 0, informers.WithNamespace(namespace))
+		podInformer := pods.factory.Core().V1().Pods()
+		podInformer.Informer()
+		pods.stopCh = make(chan struct{})
+		pods.factory.Start(pods.stopCh)
+
+		timeout := p.timeouts.list
+		if timeout == 0 {
+			timeout = podCacheSyncTimeout
+		}
+		syncCtx, cancel := boundContext(p.runCtx, timeout)
+		defer cancel()
+		for _, synced := range pods.factory.WaitForCacheSync(syncCtx.Done()) {
+			if !synced {
+				klog.Warningf("Pod cache of namespace %s didn't sync, getting its pods directly", namespace)
+				pods.stop()
+				return
+			}
+		}
+		klog.V(1).Infof("Watching the pods of namespace %s for the pod cache", namespace)
+		pods.lister = podInformer.Lister().Pods(namespace)
+	})
+	return pods.lister
+}
+
+// close stops the informers.
+func (p *podLookup) close() {
+	if !p.cached {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pods := range p.namespaces {
+		if pods.lister != nil {
+			pods.stop()
+		}
+	}
+}
+
+// stop stops the namespace's informer and waits for it to finish.
+func (n *namespacePods) stop() {
+	close(n.stopCh)
+	n.factory.Shutdown()
+}
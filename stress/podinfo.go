@@ -0,0 +1,156 @@
+package stress
+
+import (
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// defaultSystemContainers are the service mesh and logging sidecars left
+// out of the measurements unless -include-system-containers is set or
+// -exclude-containers replaces them.
+var defaultSystemContainers = []string{
+	"istio-proxy",
+	"linkerd-proxy",
+	"consul-dataplane",
+	"vault-agent",
+	"fluent-bit",
+	"fluentd",
+	"filebeat",
+	"promtail",
+}
+
+// excludedContainers returns the set of container names not to sample:
+// the comma-separated list if given, else defaultSystemContainers unless
+// includeSystem is set.
+func excludedContainers(list string, includeSystem bool) map[string]bool {
+	names := defaultSystemContainers
+	if list != "" {
+		names = strings.Split(list, ",")
+	} else if includeSystem {
+		names = nil
+	}
+	excluded := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			excluded[name] = true
+		}
+	}
+	return excluded
+}
+
+// appContainerImage returns the image and resolved image ID of the named
+// app container, the one a target measures and stresses, or of the pod's
+// first app container if name is empty (init containers are ignored). Both
+// are empty if the container has no status yet.
+func appContainerImage(pod *v1.Pod, name string) (string, string) {
+	if name == "" {
+		if len(pod.Spec.Containers) == 0 {
+			return "", ""
+		}
+		name = pod.Spec.Containers[0].Name
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == name {
+			return status.Image, status.ImageID
+		}
+	}
+	return "", ""
+}
+
+// containerMemoryLimit returns the memory limit of the named container in
+// bytes, or 0 if it has none.
+func containerMemoryLimit(pod *v1.Pod, name string) int64 {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			if limit, ok := container.Resources.Limits[v1.ResourceMemory]; ok {
+				return limit.Value()
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// containerCPULimit returns the CPU limit of the named container in
+// millicores, or 0 if it has none.
+func containerCPULimit(pod *v1.Pod, name string) int64 {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			if limit, ok := container.Resources.Limits[v1.ResourceCPU]; ok {
+				return limit.MilliValue()
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// containerCPURequest returns the CPU request of the named container in
+// millicores, or 0 if it has none.
+func containerCPURequest(pod *v1.Pod, name string) int64 {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			if request, ok := container.Resources.Requests[v1.ResourceCPU]; ok {
+				return request.MilliValue()
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// containerMemoryRequest returns the memory request of the named container
+// in bytes, or 0 if it has none.
+func containerMemoryRequest(pod *v1.Pod, name string) int64 {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			if request, ok := container.Resources.Requests[v1.ResourceMemory]; ok {
+				return request.Value()
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// podReady reports whether the pod's Ready condition is True, meaning it
+// passes its readiness probes and receives traffic.
+func podReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podTerminated reports whether all of the pod's containers have finished
+// for good, as a completed or failed Job's have.
+func podTerminated(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// podAge returns how long ago the pod was created.
+func podAge(pod *v1.Pod) time.Duration {
+	return time.Since(pod.CreationTimestamp.Time)
+}
+
+// podLifetime returns when the pod started and when its last container
+// finished, or now if none has; the start is zero if the pod never started.
+func podLifetime(pod *v1.Pod) (time.Time, time.Time) {
+	var started, finished time.Time
+	if pod.Status.StartTime != nil {
+		started = pod.Status.StartTime.Time
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.FinishedAt.After(finished) {
+			finished = status.State.Terminated.FinishedAt.Time
+		}
+	}
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	return started, finished
+}
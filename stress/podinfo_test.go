@@ -0,0 +1,39 @@
+package stress
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestContainerImages(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "proxy"}, {Name: "app"}}},
+		Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{
+			{Name: "app", Image: "web:2", ImageID: "sha256:app"},
+			{Name: "proxy", Image: "envoy:1", ImageID: "sha256:proxy"},
+		}},
+	}
+	tests := []struct {
+		container, wantImage, wantID string
+	}{
+		{"", "envoy:1", "sha256:proxy"},
+		{"app", "web:2", "sha256:app"},
+		{"missing", "", ""},
+	}
+	for _, test := range tests {
+		if image, id := appContainerImage(pod, test.container); image != test.wantImage || id != test.wantID {
+			t.Errorf("appContainerImage(%q) = %q, %q, want %q, %q", test.container, image, id, test.wantImage, test.wantID)
+		}
+	}
+
+	result := &PodResult{Image: "envoy:1", ImageID: "sha256:proxy", Containers: []ContainerUsage{
+		{Name: "proxy", Image: "envoy:1", ImageID: "sha256:proxy"},
+		{Name: "app", Image: "web:2", ImageID: "sha256:app"},
+	}}
+	for i, row := range containerRows(result) {
+		if want := result.Containers[i]; row.Image != want.Image || row.ImageID != want.ImageID {
+			t.Errorf("container row %s has image %q, %q, want %q, %q", row.Container, row.Image, row.ImageID, want.Image, want.ImageID)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package stress
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// PodResult is the measurement of one output row: a single pod, or every
+// pod of a deployment in -deployments mode. The sampler fills in the usage
+// figures and the writers turn it into columns.
+type PodResult struct {
+	Namespace string
+	// Pod is the measured pod's name, empty for aggregated deployment rows.
+	Pod string
+	// Container names the one container of a -per-container row.
+	Container string
+	// Owner and OwnerKind identify the top-level workload, see resolveOwner.
+	Owner     string
+	OwnerKind string
+	Image     string
+	ImageID   string
+	// QOSClass is the pod's Guaranteed, Burstable or BestEffort class; for
+	// deployments, that of the first pod.
+	QOSClass string
+	// Selector is the -selector, or selectors, that listed the target.
+	Selector string
+	// UID is the measured pod's UID, joined with "+" for the pods of a
+	// deployment, so a pod recreated under the same name is told apart.
+	UID string
+	// Age is how long the pod had existed when its measuring began; for
+	// deployments, that of the youngest pod.
+	Age time.Duration
+	// Status is statusDisappeared if a sampled pod went away before all of
+	// its samples were taken, empty otherwise.
+	Status string
+	// StressCheck is the -stress-min-delta check; for deployments, the
+	// first failed one. Nil if unchecked.
+	StressCheck *StressCheck
+	// Labels are the pod's labels; for deployments, those of the first pod.
+	Labels map[string]string
+
+	// Containers holds the average usage of each container sampled.
+	Containers []ContainerUsage
+	// Samples is the number of container metrics readings taken.
+	Samples int
+	// Source is the metrics source the samples came from, joined with "+"
+	// when the pods of a deployment used different ones.
+	Source         string
+	AvgCPUMilli    int64
+	AvgMemoryBytes int64
+	// Trend is whether memory rose, fell or stayed flat over the samples,
+	// see memoryTrend; empty with too few of them.
+	Trend string
+	// RawCPUMilli and RawMemoryBytes are the cycle's own averages when
+	// -ema-alpha smooths AvgCPUMilli and AvgMemoryBytes.
+	RawCPUMilli    int64
+	RawMemoryBytes int64
+
+	// CustomMetric is the -custom-metric value, if requested.
+	CustomMetric string
+	// HPA is the autoscaler targeting the owner when -hpa is set, nil if
+	// there is none.
+	HPA *HPAStatus
+	// Baseline compares the usage to a -baseline run, if one was given.
+	Baseline *BaselineDelta
+	// Extended holds the network and filesystem figures of sources that
+	// report them (the kubelet summary), nil otherwise.
+	Extended *ExtendedStats
+
+	// peak is the same row collapsed with the max strategy, for
+	// -peak-output; nil without it.
+	peak *PodResult
+	// total marks the -append-summary row, see withTotalRow.
+	total bool
+}
+
+// ExtendedStats is the pod-level I/O of a result: bytes received and sent
+// while sampling, and ephemeral storage in use at the end. Deployment rows
+// sum their pods.
+type ExtendedStats struct {
+	NetworkRxBytes        int64
+	NetworkTxBytes        int64
+	EphemeralStorageBytes int64
+}
+
+// ContainerUsage is one container's average usage over its samples.
+type ContainerUsage struct {
+	Name           string
+	Samples        int
+	AvgCPUMilli    int64
+	AvgMemoryBytes int64
+	// PeakMemoryBytes is the highest memory sample, and MemoryLimitBytes the
+	// container's memory limit, 0 if it has none.
+	PeakMemoryBytes  int64
+	MemoryLimitBytes int64
+	// CPURequestMilli and MemoryRequestBytes are the container's requests,
+	// and CPULimitMilli its CPU limit, 0 if it has none.
+	CPURequestMilli    int64
+	MemoryRequestBytes int64
+	CPULimitMilli      int64
+	// Image and ImageID are the container's, which -per-container rows
+	// report instead of the pod's.
+	Image   string
+	ImageID string
+}
+
+// Statuses of the status column: statusDisappeared marks results whose pod
+// was deleted or replaced while it was sampled, and statusTimedOut those
+// cut short by -pod-timeout.
+const (
+	statusOK          = "ok"
+	statusDisappeared = "disappeared"
+	statusTimedOut    = "timed-out"
+)
+
+// noMemoryLimit is written as the limit percentage of rows whose containers
+// have no memory limit.
+const noMemoryLimit = "no-limit"
+
+// PeakMemoryLimitPercent returns the highest peak memory of a container as a
+// percentage of its limit, and false if no sampled container has a limit.
+func (r *PodResult) PeakMemoryLimitPercent() (float64, bool) {
+	var peak float64
+	var limited bool
+	for _, container := range r.Containers {
+		if container.MemoryLimitBytes <= 0 {
+			continue
+		}
+		percent := float64(container.PeakMemoryBytes) / float64(container.MemoryLimitBytes) * 100
+		if !limited || percent > peak {
+			peak = percent
+		}
+		limited = true
+	}
+	return peak, limited
+}
+
+// containerRows splits a result into one row per container for
+// -per-container, each carrying only that container's usage, resources,
+// image and peak. The pod-level I/O figures are left off.
+func containerRows(r *PodResult) []*PodResult {
+	rows := make([]*PodResult, 0, len(r.Containers))
+	for i, container := range r.Containers {
+		row := *r
+		row.Container = container.Name
+		row.Image, row.ImageID = container.Image, container.ImageID
+		row.Containers = []ContainerUsage{container}
+		row.Samples = container.Samples
+		row.AvgCPUMilli = container.AvgCPUMilli
+		row.AvgMemoryBytes = container.AvgMemoryBytes
+		row.Extended = nil
+		row.peak = nil
+		if r.peak != nil && i < len(r.peak.Containers) {
+			peak := *r.peak
+			peak.Container = container.Name
+			peak.Image, peak.ImageID = container.Image, container.ImageID
+			peak.Containers = []ContainerUsage{r.peak.Containers[i]}
+			peak.Samples = r.peak.Containers[i].Samples
+			peak.AvgCPUMilli = r.peak.Containers[i].AvgCPUMilli
+			peak.AvgMemoryBytes = r.peak.Containers[i].AvgMemoryBytes
+			peak.Extended = nil
+			row.peak = &peak
+		}
+		rows = append(rows, &row)
+	}
+	return rows
+}
+
+// CPULimitPercent returns the highest CPU of a container as a percentage
+// of its limit, where it is throttled, and false if no sampled container
+// has a CPU limit.
+func (r *PodResult) CPULimitPercent() (float64, bool) {
+	var highest float64
+	var limited bool
+	for _, container := range r.Containers {
+		if container.CPULimitMilli <= 0 {
+			continue
+		}
+		percent := float64(container.AvgCPUMilli) / float64(container.CPULimitMilli) * 100
+		if !limited || percent > highest {
+			highest = percent
+		}
+		limited = true
+	}
+	return highest, limited
+}
+
+// CPUPerCore returns the CPU used per requested core: the usage of the
+// containers that have a CPU request divided by the sum of their requests.
+// It is false if no sampled container has a request.
+func (r *PodResult) CPUPerCore() (float64, bool) {
+	var usedMilli, requestedMilli int64
+	for _, container := range r.Containers {
+		if container.CPURequestMilli <= 0 {
+			continue
+		}
+		usedMilli += container.AvgCPUMilli
+		requestedMilli += container.CPURequestMilli
+	}
+	if requestedMilli == 0 {
+		return 0, false
+	}
+	return float64(usedMilli) / float64(requestedMilli), true
+}
+
+// HPAStatus is the CPU utilization target and current value of an HPA.
+// Utilizations are formatted percentages, or n/a if the HPA has none.
+type HPAStatus struct {
+	Name       string
+	TargetCPU  string
+	CurrentCPU string
+}
+
+// resultColumn is one named output column.
+type resultColumn struct {
+	name  string
+	value func(*PodResult) string
+}
+
+// resultLayout is the ordered set of columns a run writes.
+type resultLayout []resultColumn
+
+// newResultLayout returns the standard columns, formatting usage with
+// quantities and flagging oom_risk at oomRiskThreshold percent of a memory
+// limit. A row is identified by namespace and pod, with the workload that
+// owns the pod in its own column; deployment and grouped rows have no pod
+// and are identified by their owner instead.
+func newResultLayout(quantities quantityFormat, oomRiskThreshold float64) resultLayout {
+	return resultLayout{
+		{"namespace", func(r *PodResult) string { return r.Namespace }},
+		{"pod", func(r *PodResult) string { return r.Pod }},
+		{"owner", func(r *PodResult) string { return r.Owner }},
+		{"cpu", func(r *PodResult) string { return quantities.cpu(r.AvgCPUMilli) }},
+		{"memory", func(r *PodResult) string { return quantities.memory(r.AvgMemoryBytes) }},
+		{"owner_kind", func(r *PodResult) string { return r.OwnerKind }},
+		{"image", func(r *PodResult) string { return r.Image }},
+		{"imageID", func(r *PodResult) string { return r.ImageID }},
+		{"source", func(r *PodResult) string { return r.Source }},
+		{"mem_limit_pct", func(r *PodResult) string {
+			percent, ok := r.PeakMemoryLimitPercent()
+			if !ok {
+				return noMemoryLimit
+			}
+			return fmt.Sprintf("%.0f%%", percent)
+		}},
+		{"oom_risk", func(r *PodResult) string {
+			percent, ok := r.PeakMemoryLimitPercent()
+			if ok && percent >= oomRiskThreshold {
+				return "yes"
+			}
+			return "no"
+		}},
+		{"qos", func(r *PodResult) string { return r.QOSClass }},
+		{"status", func(r *PodResult) string {
+			if r.Status == "" {
+				return statusOK
+			}
+			return r.Status
+		}},
+		{"cpu_milli", func(r *PodResult) string { return strconv.FormatInt(r.AvgCPUMilli, 10) }},
+		{"cpu_per_core", func(r *PodResult) string {
+			perCore, ok := r.CPUPerCore()
+			if !ok {
+				return notAvailable
+			}
+			return strconv.FormatFloat(perCore, 'f', 2, 64)
+		}},
+		{"age_seconds", func(r *PodResult) string { return strconv.FormatInt(int64(r.Age/time.Second), 10) }},
+	}
+}
+
+// withCustomMetric adds the -custom-metric column, named after the metric.
+func (l resultLayout) withCustomMetric(name string) resultLayout {
+	return append(l, resultColumn{name, func(r *PodResult) string { return r.CustomMetric }})
+}
+
+// withSelector adds the column of the -selector values that matched each row.
+func (l resultLayout) withSelector() resultLayout {
+	return append(l, resultColumn{"selector", func(r *PodResult) string { return r.Selector }})
+}
+
+// withTrend adds the -trend-duration column of memory trends.
+func (l resultLayout) withTrend() resultLayout {
+	return append(l, resultColumn{"trend", func(r *PodResult) string {
+		if r.Trend == "" {
+			return notAvailable
+		}
+		return r.Trend
+	}})
+}
+
+// withEMA adds the cpu_raw and memory_raw columns of the readings that
+// -ema-alpha smoothed.
+func (l resultLayout) withEMA(quantities quantityFormat) resultLayout {
+	return append(l,
+		resultColumn{"cpu_raw", func(r *PodResult) string { return quantities.cpu(r.RawCPUMilli) }},
+		resultColumn{"memory_raw", func(r *PodResult) string { return quantities.memory(r.RawMemoryBytes) }},
+	)
+}
+
+// withContainerResources adds the -per-container columns: the container's
+// name and its own requests and limits, n/a where it sets none.
+func (l resultLayout) withContainerResources(quantities quantityFormat) resultLayout {
+	resource := func(value func(ContainerUsage) int64, format func(int64) string) func(*PodResult) string {
+		return func(r *PodResult) string {
+			if len(r.Containers) != 1 || value(r.Containers[0]) <= 0 {
+				return notAvailable
+			}
+			return format(value(r.Containers[0]))
+		}
+	}
+	return append(l,
+		resultColumn{"container", func(r *PodResult) string { return r.Container }},
+		resultColumn{"cpu_request", resource(func(c ContainerUsage) int64 { return c.CPURequestMilli }, quantities.cpu)},
+		resultColumn{"cpu_limit", resource(func(c ContainerUsage) int64 { return c.CPULimitMilli }, quantities.cpu)},
+		resultColumn{"memory_request", resource(func(c ContainerUsage) int64 { return c.MemoryRequestBytes }, quantities.memory)},
+		resultColumn{"memory_limit", resource(func(c ContainerUsage) int64 { return c.MemoryLimitBytes }, quantities.memory)},
+	)
+}
+
+// totalLabel heads the -append-summary row.
+const totalLabel = "TOTAL"
+
+// withTotalRow lets the layout write the -append-summary row too: totalLabel
+// in the first column, the summed cpu, memory and cpu_milli, and the other
+// columns left empty.
+func (l resultLayout) withTotalRow() resultLayout {
+	summed := map[string]bool{"cpu": true, "memory": true, "cpu_milli": true}
+	wrapped := make(resultLayout, len(l))
+	for i, column := range l {
+		i, value, summed := i, column.value, summed[column.name]
+		wrapped[i] = resultColumn{column.name, func(r *PodResult) string {
+			switch {
+			case !r.total || summed:
+				return value(r)
+			case i == 0:
+				return totalLabel
+			default:
+				return ""
+			}
+		}}
+	}
+	return wrapped
+}
+
+// withUID adds the -track-uid column of pod UIDs.
+func (l resultLayout) withUID() resultLayout {
+	return append(l, resultColumn{"uid", func(r *PodResult) string { return r.UID }})
+}
+
+// withRunID adds a column holding the run's ID on every row.
+func (l resultLayout) withRunID(runID string) resultLayout {
+	return append(l, resultColumn{"run_id", func(*PodResult) string { return runID }})
+}
+
+// withStressCheck adds the -stress-min-delta columns: ok or failed, and the
+// rise in usage the stress command caused.
+func (l resultLayout) withStressCheck(quantities quantityFormat) resultLayout {
+	return append(l,
+		resultColumn{"stress_check", func(r *PodResult) string {
+			switch {
+			case r.StressCheck == nil:
+				return notAvailable
+			case r.StressCheck.Passed:
+				return "ok"
+			default:
+				return "failed"
+			}
+		}},
+		resultColumn{"stress_delta", func(r *PodResult) string {
+			switch {
+			case r.StressCheck == nil:
+				return notAvailable
+			case r.StressCheck.Memory:
+				return quantities.memory(r.StressCheck.Delta)
+			default:
+				return quantities.cpu(r.StressCheck.Delta)
+			}
+		}},
+	)
+}
+
+// withHPA adds the -hpa columns.
+func (l resultLayout) withHPA() resultLayout {
+	hpa := func(get func(*HPAStatus) string, missing string) func(*PodResult) string {
+		return func(r *PodResult) string {
+			if r.HPA == nil {
+				return missing
+			}
+			return get(r.HPA)
+		}
+	}
+	return append(l,
+		resultColumn{"hpa", hpa(func(h *HPAStatus) string { return h.Name }, "")},
+		resultColumn{"hpa_target_cpu", hpa(func(h *HPAStatus) string { return h.TargetCPU }, notAvailable)},
+		resultColumn{"hpa_current_cpu", hpa(func(h *HPAStatus) string { return h.CurrentCPU }, notAvailable)},
+	)
+}
+
+// withBaseline adds the -baseline columns: the row's status relative to the
+// baseline and the CPU and memory change. Rows new to the baseline have no
+// change.
+func (l resultLayout) withBaseline(quantities quantityFormat) resultLayout {
+	delta := func(get func(*PodResult) string) func(*PodResult) string {
+		return func(r *PodResult) string {
+			if r.Baseline == nil || r.Baseline.Status == baselineNew {
+				return notAvailable
+			}
+			return get(r)
+		}
+	}
+	return append(l,
+		resultColumn{"baseline", func(r *PodResult) string {
+			if r.Baseline == nil {
+				return ""
+			}
+			return r.Baseline.Status
+		}},
+		resultColumn{"cpu_delta", delta(func(r *PodResult) string { return quantities.cpu(r.AvgCPUMilli - r.Baseline.CPUMilli) })},
+		resultColumn{"memory_delta", delta(func(r *PodResult) string { return quantities.memory(r.AvgMemoryBytes - r.Baseline.MemoryBytes) })},
+		resultColumn{"cpu_change", delta(func(r *PodResult) string { return percentChange(r.Baseline.CPUMilli, r.AvgCPUMilli) })},
+		resultColumn{"memory_change", delta(func(r *PodResult) string { return percentChange(r.Baseline.MemoryBytes, r.AvgMemoryBytes) })},
+	)
+}
+
+// withExtendedMetrics adds the -extended-metrics columns, n/a for results
+// whose source doesn't report them.
+func (l resultLayout) withExtendedMetrics(quantities quantityFormat) resultLayout {
+	extended := func(get func(*ExtendedStats) int64) func(*PodResult) string {
+		return func(r *PodResult) string {
+			if r.Extended == nil {
+				return notAvailable
+			}
+			return quantities.memory(get(r.Extended))
+		}
+	}
+	return append(l,
+		resultColumn{"net_rx", extended(func(e *ExtendedStats) int64 { return e.NetworkRxBytes })},
+		resultColumn{"net_tx", extended(func(e *ExtendedStats) int64 { return e.NetworkTxBytes })},
+		resultColumn{"fs_used", extended(func(e *ExtendedStats) int64 { return e.EphemeralStorageBytes })},
+	)
+}
+
+// index returns the position of the named column, or -1.
+func (l resultLayout) index(name string) int {
+	for i, column := range l {
+		if column.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// names returns the column names.
+func (l resultLayout) names() []string {
+	names := make([]string, len(l))
+	for i, column := range l {
+		names[i] = column.name
+	}
+	return names
+}
+
+// row formats a result as one value per column.
+func (l resultLayout) row(r *PodResult) []string {
+	row := make([]string, len(l))
+	for i, column := range l {
+		row[i] = column.value(r)
+	}
+	return row
+}
+
+// project selects columns by index, keeping all of them for nil.
+func (l resultLayout) project(indexes []int) resultLayout {
+	if indexes == nil {
+		return l
+	}
+	projected := make(resultLayout, len(indexes))
+	for i, index := range indexes {
+		projected[i] = l[index]
+	}
+	return projected
+}
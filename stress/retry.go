@@ -0,0 +1,105 @@
+package stress
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Retries of a failing output write, e.g. on a transiently full disk, and
+// the wait before the first one, doubled for each further one.
+const (
+	outputWriteRetries = 3
+	outputWriteBackoff = 500 * time.Millisecond
+)
+
+// outputWriteError is a write to the output file that still failed after
+// the retries. Rows behind it are lost, so the run stops on it rather than
+// logging and carrying on.
+type outputWriteError struct {
+	err error
+}
+
+func (e *outputWriteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *outputWriteError) Unwrap() error {
+	return e.err
+}
+
+// retryingWriter retries failed writes to the output file with backoff,
+// resuming after whatever part of the data was written. Once ctx is done a
+// failed write isn't retried, so a full disk doesn't hold up a shutdown.
+type retryingWriter struct {
+	ctx     context.Context
+	w       io.WriteCloser
+	retries int
+	backoff time.Duration
+}
+
+func newRetryingWriter(ctx context.Context, w io.WriteCloser) io.WriteCloser {
+	return &retryingWriter{ctx: ctx, w: w, retries: outputWriteRetries, backoff: outputWriteBackoff}
+}
+
+func (r *retryingWriter) Write(p []byte) (int, error) {
+	backoff := r.backoff
+	written := 0
+	for attempt := 0; ; attempt++ {
+		n, err := r.w.Write(p[written:])
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		if attempt == r.retries || r.ctx.Err() != nil {
+			return written, &outputWriteError{err: err}
+		}
+		klog.Warningf("Error writing metrics file, retrying in %s: %v", backoff, err)
+		if !sleepContext(r.ctx, backoff) {
+			return written, &outputWriteError{err: err}
+		}
+		backoff *= 2
+	}
+}
+
+func (r *retryingWriter) Close() error {
+	if err := r.w.Close(); err != nil {
+		return &outputWriteError{err: err}
+	}
+	return nil
+}
+
+// retryBudget caps the -wait-for-metrics retries of the whole run at
+// -retry-budget, so a degraded API server can't keep every pod retrying.
+// Once it is spent, failures are counted at once. A nil budget is
+// unlimited.
+type retryBudget struct {
+	remaining atomic.Int64
+	skipped   atomic.Int64
+	exhausted sync.Once
+}
+
+func newRetryBudget(total int64) *retryBudget {
+	budget := &retryBudget{}
+	budget.remaining.Store(total)
+	return budget
+}
+
+// take uses up one retry, reporting false if none are left.
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	if b.remaining.Add(-1) >= 0 {
+		return true
+	}
+	b.exhausted.Do(func() {
+		klog.Warning("-retry-budget exhausted, failing further samples without retrying")
+	})
+	b.skipped.Add(1)
+	return false
+}
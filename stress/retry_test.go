@@ -0,0 +1,79 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyWriter writes at most short bytes per call and fails the first
+// failures calls after writing them.
+type flakyWriter struct {
+	bytes.Buffer
+	short    int
+	failures int
+	calls    int
+}
+
+var errDiskFull = errors.New("no space left on device")
+
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	f.calls++
+	if f.failures == 0 {
+		return f.Buffer.Write(p)
+	}
+	f.failures--
+	if len(p) > f.short {
+		p = p[:f.short]
+	}
+	n, _ := f.Buffer.Write(p)
+	return n, errDiskFull
+}
+
+func (f *flakyWriter) Close() error { return nil }
+
+func TestRetryingWriter(t *testing.T) {
+	data := []byte("web-1,default,250m,128Mi\n")
+	tests := []struct {
+		name      string
+		failures  int
+		cancelled bool
+		wantErr   bool
+		wantCalls int
+		wantData  string
+	}{
+		{"no failures", 0, false, false, 1, string(data)},
+		{"resumes after short writes", outputWriteRetries, false, false, outputWriteRetries + 1, string(data)},
+		{"gives up after the retries", outputWriteRetries + 1, false, true, outputWriteRetries + 1, string(data[:3*(outputWriteRetries+1)])},
+		{"no retry once cancelled", 2, true, true, 1, string(data[:3])},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		if test.cancelled {
+			cancel()
+		}
+		fake := &flakyWriter{short: 3, failures: test.failures}
+		w := &retryingWriter{ctx: ctx, w: fake, retries: outputWriteRetries, backoff: time.Millisecond}
+		n, err := w.Write(data)
+		cancel()
+
+		var writeErr *outputWriteError
+		if test.wantErr != errors.As(err, &writeErr) {
+			t.Errorf("%s: error = %v, want an *outputWriteError: %v", test.name, err, test.wantErr)
+		}
+		if test.wantErr && !errors.Is(err, errDiskFull) {
+			t.Errorf("%s: error = %v, want it to wrap %v", test.name, err, errDiskFull)
+		}
+		if fake.calls != test.wantCalls {
+			t.Errorf("%s: %d writes, want %d", test.name, fake.calls, test.wantCalls)
+		}
+		if got := fake.String(); got != test.wantData {
+			t.Errorf("%s: wrote %q, want %q", test.name, got, test.wantData)
+		}
+		if n != len(test.wantData) {
+			t.Errorf("%s: Write returned %d, want %d", test.name, n, len(test.wantData))
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package stress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// rotateOptions controls output rotation in -watch mode.
+type rotateOptions struct {
+	maxSize    int64         // rotate once the file reaches this many bytes, 0 to disable
+	interval   time.Duration // rotate when the file is this old, 0 to disable
+	maxBackups int           // rotated files to keep, 0 to keep all
+}
+
+// countingFile tracks how many bytes have been written to a file. The file
+// is not embedded so that io.WriteString can't bypass the count.
+type countingFile struct {
+	file    *os.File
+	written int64
+}
+
+func (c *countingFile) Write(p []byte) (int, error) {
+	n, err := c.file.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+func (c *countingFile) Close() error {
+	return c.file.Close()
+}
+
+// backupTimestamp is the UTC time layout of rotated file names.
+const backupTimestamp = "20060102T150405.000Z"
+
+// rotatingWriter rolls the output file between rows once it grows too large
+// or too old. The current file is renamed to <name>-<timestamp><ext>, a fresh
+// file with its own header takes its place, and the oldest backups beyond
+// maxBackups are removed.
+type rotatingWriter struct {
+	path   string
+	opts   rotateOptions
+	open   func(io.WriteCloser) ResultWriter
+	file   *countingFile
+	opened time.Time
+	next   ResultWriter
+}
+
+func newRotatingWriter(path string, file *os.File, opts rotateOptions, open func(io.WriteCloser) ResultWriter) ResultWriter {
+	counted := &countingFile{file: file}
+	return &rotatingWriter{path: path, opts: opts, open: open, file: counted, opened: time.Now(), next: open(counted)}
+}
+
+func (r *rotatingWriter) WriteHeader() error {
+	return r.next.WriteHeader()
+}
+
+func (r *rotatingWriter) WriteRow(result *PodResult) error {
+	if r.due() {
+		if err := r.rotate(); err != nil {
+			return fmt.Errorf("rotating %s: %w", r.path, err)
+		}
+	}
+	return r.next.WriteRow(result)
+}
+
+func (r *rotatingWriter) Close() error {
+	return r.next.Close()
+}
+
+// due reports whether the current file should be rotated before the next row.
+func (r *rotatingWriter) due() bool {
+	if r.opts.maxSize > 0 && r.file.written >= r.opts.maxSize {
+		return true
+	}
+	return r.opts.interval > 0 && time.Since(r.opened) >= r.opts.interval
+}
+
+func (r *rotatingWriter) rotate() error {
+	if err := r.next.Close(); err != nil {
+		return err
+	}
+	now := time.Now()
+	ext := filepath.Ext(r.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(r.path, ext), now.UTC().Format(backupTimestamp), ext)
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+	klog.Infof("Rotated %s to %s", r.path, backup)
+
+	file, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	r.file = &countingFile{file: file}
+	r.opened = now
+	r.next = r.open(r.file)
+	if err := r.next.WriteHeader(); err != nil {
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond maxBackups. Backup names
+// embed a sortable timestamp, so lexical order is age order. Only names
+// whose suffix parses as that timestamp count, so siblings such as a
+// -peak-output metrics-peak.csv are never removed.
+func (r *rotatingWriter) prune() {
+	if r.opts.maxBackups <= 0 {
+		return
+	}
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		klog.Errorf("Error listing rotated files: %v", err)
+		return
+	}
+	var backups []string
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(match, base+"-"), ext)
+		if _, err := time.Parse(backupTimestamp, stamp); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > r.opts.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			klog.Errorf("Error removing rotated file: %v", err)
+		}
+		backups = backups[1:]
+	}
+}
@@ -0,0 +1,1414 @@
+// Package stress samples the CPU and memory usage of Kubernetes pods and
+// writes the averages per pod or deployment. The stresstest command is a
+// thin wrapper around Run.
+package stress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Config controls a Run. Each field corresponds to the stresstest flag of
+// the same name, e.g. OutputDir to -output-dir; see DefaultConfig for the
+// defaults.
+type Config struct {
+	// Targets
+	Input          string
+	InputConfigMap string
+	InputComment   string // skip lines starting with this character
+	Strict         bool   // require a header of known CSV columns
+	Namespace      string
+	AllNamespaces  bool
+	// Selectors are the label selectors to list with; each is listed on
+	// its own and the results unioned.
+	Selectors   []string
+	Deployments bool
+	// GroupByLabel aggregates each cycle's rows into one per value of this
+	// pod label.
+	GroupByLabel string
+	// DiscoverNamespace resolves the namespace of pod rows that only give
+	// a name, by a unique match across the cluster.
+	DiscoverNamespace bool
+	OnlyWithMetrics   bool
+	RequireReady      bool
+	MinAge            time.Duration
+	ReportZero        bool // false omits the rows of exactly zero usage
+	WaitForPods       time.Duration
+	ListPageSize      int64
+	IncludeSelf       bool // measure the tool's own pod, see selfPod
+	IncludeTerminated bool
+	ResolveOwner      bool
+	SampleRate        float64
+	Seed              int64 // 0 for a time-based seed
+	// MaxPodsPerNamespace caps the targets of any one namespace (0 = no
+	// cap); over it the run fails, or with TruncatePerNamespace keeps the
+	// first ones.
+	MaxPodsPerNamespace  int
+	TruncatePerNamespace bool
+
+	// Sampling
+	Mode            string // metrics, stress or both, see checkMode
+	Source          string
+	PrometheusURL   string
+	SampleStrategy  string
+	Weighted        bool
+	CPURate         bool
+	RefreshPod      bool
+	WaitForMetrics  time.Duration
+	PodTimeout      time.Duration
+	RetryBudget     int64 // total WaitForMetrics retries, 0 for no limit
+	BurstDuration   time.Duration
+	BurstInterval   time.Duration
+	CustomMetric    string
+	CustomMetricAPI string
+	HPA             bool
+	Stress          string // cpu or memory, see StressCommand
+	// StressCommand overrides the -stress mode's command template, which
+	// is rendered per pod with StressDuration and StressMemMB.
+	StressCommand   string
+	StressDuration  time.Duration
+	StressMemMB     int
+	Container       string
+	ExtendedMetrics bool
+	// StressMinDelta is the rise in the stressed container's CPU, or memory
+	// in memory mode, expected StressVerifyDelay after the command starts,
+	// e.g. 200m or 64Mi; empty skips the check. StressVerifyAbort skips pods
+	// that fall short instead of warning.
+	StressMinDelta    string
+	StressVerifyDelay time.Duration
+	StressVerifyAbort bool
+	// MaxStressPerNode caps how many pods on one node, by pod.Spec.NodeName,
+	// are stressed at once (0 = no cap).
+	MaxStressPerNode int
+	// TrendDuration samples each pod every TrendInterval for this long
+	// instead of the usual five samples, and adds a memory trend column.
+	TrendDuration time.Duration
+	TrendInterval time.Duration
+	// ExcludeContainers replaces the built-in list of sidecars not sampled,
+	// which IncludeSystemContainers turns off.
+	ExcludeContainers       string
+	IncludeSystemContainers bool
+	// ContainerRegex, if set, samples only the containers whose names it
+	// matches, on top of the exclusions.
+	ContainerRegex string
+
+	// Output
+	Output           string
+	OutputDir        string
+	NoClobber        bool
+	SplitByNamespace bool
+	PeakOutput       string // the rows again, collapsed with strategyMax
+	AppendSummary    bool   // a last TOTAL row of the summed cpu and memory
+	Format           string
+	Template         string
+	CSVCRLF          bool
+	CSVAlwaysQuote   bool
+	Pretty           bool
+	Columns          string
+	Sort             string
+	Limit            int
+	Precision        int
+	MemUnit          string
+	NamespaceMap     string
+	Baseline         string
+	OOMRiskThreshold float64
+	IncludeRunID     bool
+	PerContainer     bool
+	TrackUID         bool
+	// Anonymize renames namespaces, workloads and pods in the output,
+	// writing the aliases to AnonymizeMap.
+	Anonymize       bool
+	AnonymizeMap    string
+	SQLite          string
+	CPUPrice        float64 // per core-hour
+	MemPrice        float64 // per GiB-hour
+	CostDuration    time.Duration
+	ExportURL       string
+	ExportBatchSize int
+	ExportRetries   int
+	OTLPEndpoint    string // OTLP/HTTP collector URL for CPU and memory gauges
+	// AlertWebhook receives the rows over OOMRiskThreshold, or over
+	// AlertCPUThreshold percent of their CPU limit, at the end of the run.
+	AlertWebhook      string
+	AlertCPUThreshold float64
+	// CompareRequests logs the TopWasteful rows with the most unused
+	// requests at the end of the run.
+	CompareRequests bool
+	TopWasteful     int
+	// FailOn is a predicate such as mem_ratio>0.95, see parseFailOn; Run
+	// returns ErrFailOn if any row matches it.
+	FailOn string
+
+	// Run control
+	MaxRuntime  time.Duration
+	Concurrency int
+	OrderBuffer int // 0 for 4x Concurrency
+	// ParallelNamespaces measures, and with AllNamespaces lists, this many
+	// namespaces at once, each with Concurrency workers (0 = off).
+	ParallelNamespaces int
+	Watch              bool
+	WatchInterval      time.Duration
+	ActiveWindow       string
+	ActiveWindowTZ     string
+	MaxOutputSize      int64
+	RotateInterval     time.Duration
+	MaxBackups         int
+	EMAAlpha           float64 // 0 for each cycle's raw readings
+
+	// Cluster access
+	Kubeconfig            string
+	InsecureSkipTLSVerify bool
+	CertificateAuthority  string
+	// Timeout bounds each API call; GetTimeout, ListTimeout and ExecTimeout
+	// override it for that kind of call. Zero is no bound.
+	Timeout     time.Duration
+	GetTimeout  time.Duration
+	ListTimeout time.Duration
+	ExecTimeout time.Duration
+	// As and AsGroups impersonate a user or service account, like kubectl's
+	// --as and --as-group. The real credentials need RBAC for the
+	// impersonate verb on users (or serviceaccounts) and groups.
+	As       string
+	AsGroups []string
+}
+
+// DefaultConfig returns the Config used when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		Input:             "pods.csv",
+		ResolveOwner:      true,
+		ReportZero:        true,
+		SampleRate:        1,
+		Source:            sourceMetricsServer,
+		Mode:              modeMetrics,
+		SampleStrategy:    strategyMean,
+		StressDuration:    10 * time.Second,
+		StressMemMB:       128,
+		StressVerifyDelay: 15 * time.Second,
+		BurstInterval:     200 * time.Millisecond,
+		TrendInterval:     5 * time.Minute,
+		Output:            defaultOutputPath,
+		Format:            formatCSV,
+		MemUnit:           "Mi",
+		OOMRiskThreshold:  90,
+		AnonymizeMap:      "anonymize-map.csv",
+		TopWasteful:       10,
+		CostDuration:      730 * time.Hour,
+		ExportBatchSize:   10,
+		ExportRetries:     3,
+		Concurrency:       1,
+		ListPageSize:      500,
+		WatchInterval:     time.Minute,
+	}
+}
+
+// Summary describes a finished Run.
+type Summary struct {
+	// RunID identifies the run in its logs and, with IncludeRunID, output.
+	RunID string
+	// OutputPath is the metrics file written, after resolving OutputDir,
+	// or the OutputDir of the SplitByNamespace files.
+	OutputPath        string
+	OutputPaths       []string // OutputPath, then one file per further -format
+	PeakOutputPath    string
+	Rows              int
+	SamplesAttempted  int64
+	SamplesSuccessful int64
+	APIErrors         int64
+	// Disappeared counts the pods deleted or replaced while sampled.
+	Disappeared int64
+	// Failures counts the pods that failed, or got no samples, by bucket,
+	// e.g. forbidden or metrics-unavailable; see classifyFailure.
+	Failures map[string]int64
+	// TimedOut counts the pods cut short by -pod-timeout.
+	TimedOut int64
+	// TooYoung counts the pods skipped as younger than -min-age.
+	TooYoung int64
+	// ZeroUsage counts the rows of zero CPU and memory omitted without
+	// -report-zero.
+	ZeroUsage int64
+	// FailOnMatches lists the rows that matched -fail-on, once per pod, or
+	// container, with its latest matching value.
+	FailOnMatches []FailOnMatch
+	// Truncated counts the targets dropped by -truncate-per-namespace.
+	Truncated int
+	// NotRunning lists the pods still missing or not Running once
+	// -wait-for-pods timed out.
+	NotRunning []Target
+	// RetriesSkipped counts the retries refused once -retry-budget ran out.
+	RetriesSkipped int64
+	// Wasteful lists the rows with the most unused requests for
+	// -compare-requests, most wasted memory first.
+	Wasteful []Waste
+	// NamespaceTimings is how long each namespace of the last cycle took
+	// with -parallel-namespaces.
+	NamespaceTimings []NamespaceTiming
+	// EstimatedCost totals the cost column, zero without -cpu-price or
+	// -mem-price.
+	EstimatedCost float64
+}
+
+// Run measures the configured targets and writes their metrics. If ctx is
+// cancelled or MaxRuntime elapses before every target was measured, the
+// partial results are kept and Run returns context.Canceled or
+// context.DeadlineExceeded. In watch mode cancelling ctx is the normal way
+// to stop and returns nil.
+func Run(ctx context.Context, cfg Config) (Summary, error) {
+	defer klog.Flush()
+	var summary Summary
+
+	// Stamp the run so its output can be matched to its logs
+	summary.RunID = uuid.NewString()
+	klog.Infof("Starting run %s", summary.RunID)
+	defer func() { klog.Infof("Finished run %s", summary.RunID) }()
+
+	quantities, err := newQuantityFormat(cfg.Precision, cfg.MemUnit)
+	if err != nil {
+		return summary, fmt.Errorf("invalid output options: %w", err)
+	}
+	if cfg.OOMRiskThreshold <= 0 {
+		return summary, fmt.Errorf("-oom-risk-threshold must be positive")
+	}
+	layout := newResultLayout(quantities, cfg.OOMRiskThreshold)
+	var previous *baseline
+	if cfg.Baseline != "" {
+		if cfg.Watch {
+			return summary, fmt.Errorf("-baseline compares a single run and can't be used with -watch")
+		}
+		previous, err = readBaseline(cfg.Baseline, layout.names())
+		if err != nil {
+			return summary, err
+		}
+	}
+	if len(cfg.Selectors) > 0 {
+		layout = layout.withSelector()
+	}
+	if cfg.CustomMetric != "" {
+		layout = layout.withCustomMetric(cfg.CustomMetric)
+	}
+	if cfg.HPA {
+		layout = layout.withHPA()
+	}
+	if cfg.ExtendedMetrics {
+		layout = layout.withExtendedMetrics(quantities)
+	}
+	if cfg.IncludeRunID {
+		layout = layout.withRunID(summary.RunID)
+	}
+	if cfg.PerContainer {
+		if previous != nil {
+			return summary, fmt.Errorf("-baseline compares whole rows and can't be used with -per-container")
+		}
+		layout = layout.withContainerResources(quantities)
+	}
+	if cfg.EMAAlpha != 0 {
+		if !cfg.Watch {
+			return summary, fmt.Errorf("-ema-alpha smooths across cycles and requires -watch")
+		}
+		if cfg.EMAAlpha < 0 || cfg.EMAAlpha > 1 {
+			return summary, fmt.Errorf("-ema-alpha must be in (0, 1]")
+		}
+		layout = layout.withEMA(quantities)
+	}
+	if cfg.TrendDuration > 0 {
+		if cfg.TrendInterval <= 0 {
+			return summary, fmt.Errorf("-trend-interval must be positive")
+		}
+		layout = layout.withTrend()
+	}
+	// With -track-uid, the UID each pod had last cycle, to log replacements
+	var podUIDs map[string]string
+	if cfg.TrackUID {
+		layout = layout.withUID()
+		podUIDs = make(map[string]string)
+	}
+	var waste *wasteReport
+	if cfg.CompareRequests {
+		waste = &wasteReport{top: cfg.TopWasteful}
+	}
+	var alerts *alertReport
+	if cfg.AlertWebhook != "" {
+		alerts = newAlertReport(cfg.AlertWebhook, cfg.OOMRiskThreshold, cfg.AlertCPUThreshold)
+	}
+	var otlpEndpoint string
+	if cfg.OTLPEndpoint != "" {
+		otlpEndpoint, err = parseOTLPEndpoint(cfg.OTLPEndpoint)
+		if err != nil {
+			return summary, err
+		}
+	}
+	var failOn *failOnPredicate
+	failOnIndex := make(map[FailOnMatch]int) // into FailOnMatches, keyed without the value
+	if cfg.FailOn != "" {
+		failOn, err = parseFailOn(cfg.FailOn)
+		if err != nil {
+			return summary, err
+		}
+	}
+	var anon *anonymizer
+	if cfg.Anonymize {
+		anon = newAnonymizer()
+	}
+	prices := pricing{cpuCoreHour: cfg.CPUPrice, memGiBHour: cfg.MemPrice, hours: cfg.CostDuration.Hours()}
+	if prices.enabled() {
+		layout = layout.withCost(prices)
+	}
+	if previous != nil {
+		layout = layout.withBaseline(quantities)
+	}
+	formats, err := parseFormats(cfg.Format)
+	if err != nil {
+		return summary, fmt.Errorf("invalid output options: %w", err)
+	}
+	tableOpts, err := parseTableOptions(formats[0], cfg.Columns, cfg.Sort, cfg.Limit, layout.names())
+	if err != nil {
+		return summary, fmt.Errorf("invalid output options: %w", err)
+	}
+	if cfg.Watch && tableOpts.buffered() {
+		return summary, fmt.Errorf("-sort and -limit need the full result and can't be used with -watch")
+	}
+	if cfg.Watch && cfg.Pretty {
+		return summary, fmt.Errorf("-pretty prints the full result at the end and can't be used with -watch")
+	}
+	if cfg.AppendSummary && cfg.Watch {
+		return summary, fmt.Errorf("-append-summary totals a finished run and can't be used with -watch")
+	}
+	if cfg.AppendSummary && (formats[0] != formatCSV || cfg.Template != "") {
+		return summary, fmt.Errorf("-append-summary writes a CSV row and needs -format csv")
+	}
+	if cfg.WaitForPods > 0 && cfg.Deployments {
+		return summary, fmt.Errorf("-wait-for-pods waits for pod targets and can't be used with -deployments")
+	}
+	var window *activeWindow
+	if cfg.ActiveWindow != "" {
+		if !cfg.Watch {
+			return summary, fmt.Errorf("-active-window requires -watch")
+		}
+		window, err = parseActiveWindow(cfg.ActiveWindow, cfg.ActiveWindowTZ)
+		if err != nil {
+			return summary, fmt.Errorf("invalid watch options: %w", err)
+		}
+	}
+	var tmpl *template.Template
+	if cfg.Template != "" {
+		tmpl, err = parseResultTemplate(cfg.Template, quantities)
+		if err != nil {
+			return summary, fmt.Errorf("invalid output options: %w", err)
+		}
+		if len(formats) > 1 {
+			return summary, fmt.Errorf("-template replaces -format and can't be used with several formats")
+		}
+	}
+	ext := formatExt(formats[0])
+	if tmpl != nil {
+		ext = "txt"
+	}
+	start := time.Now()
+	metricsPath, err := resolveOutputPath(cfg.Output, cfg.OutputDir, ext, start)
+	if err != nil {
+		return summary, fmt.Errorf("preparing output: %w", err)
+	}
+	// Each further -format goes to the metrics file with its own extension
+	formatPaths := make(map[string]string, len(formats)-1)
+	for _, format := range formats[1:] {
+		path := formatOutputPath(metricsPath, format)
+		if path == metricsPath {
+			return summary, fmt.Errorf("-format %s would overwrite the metrics file %s; give -output a .%s extension", format, metricsPath, formatExt(formats[0]))
+		}
+		formatPaths[format] = path
+	}
+	if cfg.SplitByNamespace {
+		if cfg.OutputDir == "" {
+			return summary, fmt.Errorf("-split-by-namespace requires -output-dir")
+		}
+		if cfg.Watch && (cfg.MaxOutputSize > 0 || cfg.RotateInterval > 0) {
+			return summary, fmt.Errorf("-split-by-namespace can't be used with -max-output-size or -rotate-interval")
+		}
+	}
+	if cfg.Mode != modeStress && cfg.SplitByNamespace {
+		// OutputPaths fills in as the namespaces' files are created
+		summary.OutputPath = cfg.OutputDir
+	} else if cfg.Mode != modeStress {
+		summary.OutputPath = metricsPath
+		summary.OutputPaths = []string{metricsPath}
+		for _, format := range formats[1:] {
+			summary.OutputPaths = append(summary.OutputPaths, formatPaths[format])
+		}
+	}
+	if cfg.PeakOutput != "" {
+		for _, path := range append([]string{metricsPath}, summary.OutputPaths...) {
+			if cfg.PeakOutput == path {
+				return summary, fmt.Errorf("-peak-output must differ from the metrics file %s", path)
+			}
+		}
+		summary.PeakOutputPath = cfg.PeakOutput
+	}
+	if cfg.NoClobber && cfg.Mode != modeStress {
+		paths := summary.OutputPaths
+		if summary.PeakOutputPath != "" {
+			paths = append(paths, summary.PeakOutputPath)
+		}
+		if err := checkNoClobber(paths); err != nil {
+			return summary, err
+		}
+	}
+
+	if err := checkSampleStrategy(cfg.SampleStrategy); err != nil {
+		return summary, fmt.Errorf("invalid sampling options: %w", err)
+	}
+	if cfg.Weighted && cfg.SampleStrategy != strategyMean {
+		return summary, fmt.Errorf("-weighted only applies to -sample-strategy %s", strategyMean)
+	}
+	agg := aggregation{strategy: cfg.SampleStrategy, weighted: cfg.Weighted, cpuRate: cfg.CPURate}
+
+	comment, err := parseInputComment(cfg.InputComment)
+	if err != nil {
+		return summary, err
+	}
+	if err := checkMode(cfg.Mode, cfg.Stress, cfg.StressCommand); err != nil {
+		return summary, err
+	}
+	stressMode := cfg.Stress
+	if cfg.Mode != modeMetrics && stressMode == "" {
+		stressMode = stressCPU
+	}
+	stressCommand, err := parseStressCommand(stressMode, cfg.StressCommand)
+	if err != nil {
+		return summary, err
+	}
+	var verifyStress *stressVerification
+	if cfg.StressMinDelta != "" {
+		minDelta, err := resource.ParseQuantity(cfg.StressMinDelta)
+		if err != nil {
+			return summary, fmt.Errorf("invalid -stress-min-delta: %w", err)
+		}
+		verifyStress = &stressVerification{memory: cfg.Stress == stressMemory, minDelta: minDelta.MilliValue(), delay: cfg.StressVerifyDelay, abort: cfg.StressVerifyAbort}
+		if verifyStress.memory {
+			verifyStress.minDelta = minDelta.Value()
+		}
+		layout = layout.withStressCheck(quantities)
+	}
+	if cfg.MaxStressPerNode < 0 {
+		return summary, fmt.Errorf("-max-stress-per-node must not be negative")
+	}
+	if cfg.MaxStressPerNode > 0 && stressCommand == nil {
+		return summary, fmt.Errorf("-max-stress-per-node only applies with -mode %s or %s", modeStress, modeBoth)
+	}
+	// The stress commands time out in whole seconds, and timeout 0 never does
+	if stressCommand != nil && cfg.StressDuration < time.Second {
+		return summary, fmt.Errorf("-stress-duration must be at least 1s")
+	}
+
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		return summary, fmt.Errorf("-sample-rate must be in (0, 1]")
+	}
+	var containerPattern *regexp.Regexp
+	if cfg.ContainerRegex != "" {
+		containerPattern, err = regexp.Compile(cfg.ContainerRegex)
+		if err != nil {
+			return summary, fmt.Errorf("invalid -container-regex: %w", err)
+		}
+	}
+	if cfg.Timeout < 0 || cfg.GetTimeout < 0 || cfg.ListTimeout < 0 || cfg.ExecTimeout < 0 {
+		return summary, fmt.Errorf("-timeout, -get-timeout, -list-timeout and -exec-timeout must not be negative")
+	}
+	if cfg.IncludeTerminated && cfg.PrometheusURL == "" {
+		return summary, fmt.Errorf("-include-terminated reads finished pods' peaks from Prometheus and requires -prometheus-url")
+	}
+	if len(cfg.Selectors) > 0 && cfg.Namespace == "" && !cfg.AllNamespaces {
+		return summary, fmt.Errorf("-selector requires -namespace or -all-namespaces")
+	}
+
+	if cfg.OrderBuffer <= 0 {
+		cfg.OrderBuffer = 4 * cfg.Concurrency
+	}
+
+	// Bound the whole run by -max-runtime
+	if cfg.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxRuntime)
+		defer cancel()
+	}
+	// A write to the output file that fails for good stops the run, see emit
+	ctx, stopRun := context.WithCancel(ctx)
+	defer stopRun()
+	var outputErr error
+
+	// Initialize Kubernetes client using kubeconfig
+	config, err := clientConfig(ctx, cfg)
+	if err != nil {
+		return summary, err
+	}
+
+	timeouts := newCallTimeouts(cfg.Timeout, cfg.GetTimeout, cfg.ListTimeout, cfg.ExecTimeout)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return summary, fmt.Errorf("creating clientset: %w", err)
+	}
+
+	// Initialize Metrics client
+	metricsClient, err := versioned.NewForConfig(config)
+	if err != nil {
+		return summary, fmt.Errorf("creating metrics clientset: %w", err)
+	}
+
+	var namespaceMap map[string]string
+	if cfg.NamespaceMap != "" {
+		namespaceMap, err = readNamespaceMap(cfg.NamespaceMap)
+		if err != nil {
+			return summary, fmt.Errorf("reading namespace map: %w", err)
+		}
+	}
+
+	// Set up the metrics sources to sample from
+	sources, err := newMetricsSources(cfg.Source, clientset, metricsClient, cfg.PrometheusURL)
+	if err != nil {
+		return summary, fmt.Errorf("configuring metrics source: %w", err)
+	}
+	// Only metrics-server is required; -source auto falls back per pod.
+	if cfg.Source == sourceMetricsServer {
+		if err := checkMetricsAPI(clientset.Discovery()); err != nil {
+			return summary, fmt.Errorf("preflight: %w", err)
+		}
+	}
+
+	// Initialize the custom metrics client, if a custom metric was requested
+	var customMetrics *customMetricReader
+	if cfg.CustomMetric != "" {
+		customMetrics, err = newCustomMetricReader(config, clientset.Discovery(), cfg.CustomMetric, cfg.CustomMetricAPI)
+		if err != nil {
+			return summary, fmt.Errorf("creating custom metrics client: %w", err)
+		}
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	// A CSV input file is streamed into the first measurement cycle as it is
+	// read, and paged listing a page at a time, unless a filter needs every
+	// target up front
+	streamable := !(cfg.OnlyWithMetrics && !cfg.Deployments) && cfg.MaxPodsPerNamespace == 0 && cfg.ParallelNamespaces == 0 &&
+		cfg.WaitForPods == 0
+	streamInput := cfg.Namespace == "" && !cfg.AllNamespaces && cfg.InputConfigMap == "" && !manifestInput(cfg.Input) &&
+		streamable && !cfg.DiscoverNamespace && !cfg.Strict
+	streamList := (cfg.Namespace != "" || cfg.AllNamespaces) && cfg.ListPageSize > 0 && len(cfg.Selectors) <= 1 && streamable
+	streaming := streamInput || streamList
+	var inputFile io.ReadCloser
+
+	// List the targets from the cluster, or read pod and namespace names
+	// from the input file or ConfigMap
+	var targets []Target
+	if streaming {
+		if streamInput {
+			inputFile, err = openInput(cfg.Input)
+			if err != nil {
+				return summary, fmt.Errorf("opening pods file: %w", err)
+			}
+		}
+		if cfg.SampleRate < 1 {
+			klog.Infof("Sampling targets at -sample-rate %g (-seed %d) as they are read", cfg.SampleRate, seed)
+		}
+	} else if cfg.AllNamespaces && cfg.ParallelNamespaces > 0 {
+		targets, err = listTargetsByNamespace(ctx, clientset, cfg.Selectors, cfg.Deployments, cfg.ListPageSize, timeouts, cfg.ParallelNamespaces)
+		if apierrors.IsForbidden(err) {
+			klog.Warningf("Not allowed to list namespaces in parallel, listing as usual: %v", err)
+			targets, err = listTargets(ctx, clientset, cfg.Namespace, cfg.AllNamespaces, cfg.Selectors, cfg.Deployments, cfg.ListPageSize, timeouts)
+		}
+		if err != nil {
+			return summary, err
+		}
+	} else if cfg.Namespace != "" || cfg.AllNamespaces {
+		targets, err = listTargets(ctx, clientset, cfg.Namespace, cfg.AllNamespaces, cfg.Selectors, cfg.Deployments, cfg.ListPageSize, timeouts)
+		if err != nil {
+			return summary, err
+		}
+	} else {
+		var records [][]string
+		if cfg.InputConfigMap != "" {
+			getCtx, cancel := timeouts.forGet(ctx)
+			records, err = readConfigMapTargets(getCtx, clientset, cfg.InputConfigMap, comment)
+			cancel()
+		} else {
+			records, err = readTargets(cfg.Input, comment)
+		}
+		if err != nil {
+			return summary, fmt.Errorf("reading pods: %w", err)
+		}
+		if cfg.Strict && (cfg.InputConfigMap != "" || !manifestInput(cfg.Input)) {
+			records, err = checkInputHeader(records, cfg.Deployments, cfg.DiscoverNamespace)
+			if err != nil {
+				return summary, fmt.Errorf("-strict: %w", err)
+			}
+		}
+		if cfg.DiscoverNamespace && !cfg.Deployments {
+			records, err = discoverNamespaces(ctx, clientset, records, cfg.ListPageSize, timeouts)
+			if err != nil {
+				return summary, err
+			}
+		}
+		targets = parseTargets(records, cfg.Deployments)
+	}
+
+	if cfg.OnlyWithMetrics && !cfg.Deployments {
+		var filtered int
+		targets, filtered, err = filterTargetsWithMetrics(ctx, metricsClient, targets, timeouts)
+		if err != nil {
+			return summary, fmt.Errorf("filtering pods by metrics: %w", err)
+		}
+		klog.Infof("Filtered out %d pods without metrics, %d remaining", filtered, len(targets))
+	}
+
+	// Skip the pod this tool runs in, unless -include-self
+	self, inPod := selfPod()
+	skipSelf := inPod && !cfg.IncludeSelf && !cfg.Deployments
+	if skipSelf && !streaming {
+		targets = dropSelf(targets, self)
+	}
+
+	// Streamed targets are sampled and checked for self as they arrive,
+	// counting those that matched for the sampling log line
+	rng := rand.New(rand.NewSource(seed))
+	streamMatched := 0
+	keep := func(target Target) bool {
+		if skipSelf && isSelf(target, self) {
+			return false
+		}
+		streamMatched++
+		return cfg.SampleRate >= 1 || rng.Float64() < cfg.SampleRate
+	}
+	var listFeed <-chan Target
+	if streamList {
+		listFeed, err = streamListTargets(ctx, clientset, cfg.Namespace, cfg.AllNamespaces, cfg.Selectors, cfg.Deployments, cfg.ListPageSize, timeouts, keep, &targets)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	if cfg.SampleRate < 1 && !streaming {
+		matched := len(targets)
+		targets = sampleTargets(targets, cfg.SampleRate, rand.New(rand.NewSource(seed)))
+		klog.Infof("Sampled %d of %d matched targets at -sample-rate %g (-seed %d)", len(targets), matched, cfg.SampleRate, seed)
+	}
+
+	if cfg.MaxPodsPerNamespace > 0 {
+		targets, summary.Truncated, err = capTargetsPerNamespace(targets, cfg.MaxPodsPerNamespace, cfg.TruncatePerNamespace)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	// Hold off sampling until freshly applied pods are up
+	if cfg.WaitForPods > 0 {
+		summary.NotRunning, err = waitForPods(ctx, clientset, targets, cfg.WaitForPods, timeouts)
+		if err != nil {
+			return summary, fmt.Errorf("waiting for pods: %w", err)
+		}
+	}
+
+	// Create a file to export metrics; -mode stress records nothing
+	var metricsOut ResultWriter = discardResultWriter{}
+	var peakOut ResultWriter
+	var otlp *otlpWriter // kept to prune the gauges after each cycle
+	if cfg.Mode != modeStress {
+		projected := layout.project(tableOpts.columns)
+		writerOpts := writerOptions{csvCRLF: cfg.CSVCRLF, csvAlwaysQuote: cfg.CSVAlwaysQuote}
+		newWriter := func(w io.WriteCloser, opts writerOptions) ResultWriter {
+			if cfg.AppendSummary {
+				return newTotalsWriter(newFormatWriter(formats[0], nil, newRetryingWriter(ctx, w), projected.withTotalRow(), opts))
+			}
+			return newFormatWriter(formats[0], tmpl, newRetryingWriter(ctx, w), projected, opts)
+		}
+		var formatOut ResultWriter
+		if cfg.SplitByNamespace {
+			// Each namespace gets a file of every format in -output-dir,
+			// created with its first row and starting with a header
+			splitOpts := writerOpts
+			splitOpts.csvHeader = true
+			formatOut = newNamespaceSplitWriter(func(namespace string) (ResultWriter, error) {
+				path := namespaceOutputPath(cfg.OutputDir, namespace, ext)
+				paths := []string{path}
+				for _, format := range formats[1:] {
+					paths = append(paths, formatOutputPath(path, format))
+				}
+				if cfg.NoClobber {
+					if err := checkNoClobber(paths); err != nil {
+						return nil, err
+					}
+				}
+				var out teeResultWriter
+				for i, path := range paths {
+					file, err := os.Create(path)
+					if err != nil {
+						out.Close()
+						return nil, fmt.Errorf("creating metrics file for namespace %s: %w", namespace, err)
+					}
+					if i == 0 {
+						out = append(out, newWriter(file, splitOpts))
+					} else {
+						out = append(out, newFormatWriter(formats[i], nil, newRetryingWriter(ctx, file), projected, splitOpts))
+					}
+				}
+				summary.OutputPaths = append(summary.OutputPaths, paths...)
+				klog.V(1).Infof("Writing namespace %s to %s", namespace, path)
+				return out, nil
+			})
+		} else {
+			metricsFile, err := os.Create(metricsPath)
+			if err != nil {
+				return summary, fmt.Errorf("creating metrics file: %w", err)
+			}
+			rotate := cfg.Watch && (cfg.MaxOutputSize > 0 || cfg.RotateInterval > 0)
+			rotation := rotateOptions{maxSize: cfg.MaxOutputSize, interval: cfg.RotateInterval, maxBackups: cfg.MaxBackups}
+			if rotate {
+				formatOut = newRotatingWriter(metricsPath, metricsFile, rotation, func(w io.WriteCloser) ResultWriter {
+					return newWriter(w, writerOpts)
+				})
+			} else {
+				formatOut = newWriter(metricsFile, writerOpts)
+			}
+			// Each further -format file rotates on its own size and age
+			for _, format := range formats[1:] {
+				formatFile, err := os.Create(formatPaths[format])
+				if err != nil {
+					return summary, fmt.Errorf("creating %s metrics file: %w", format, err)
+				}
+				format := format
+				newFormatFile := func(w io.WriteCloser) ResultWriter {
+					return newFormatWriter(format, nil, newRetryingWriter(ctx, w), projected, writerOpts)
+				}
+				if rotate {
+					formatOut = teeResultWriter{formatOut, newRotatingWriter(formatPaths[format], formatFile, rotation, newFormatFile)}
+				} else {
+					formatOut = teeResultWriter{formatOut, newFormatFile(formatFile)}
+				}
+			}
+		}
+		if cfg.SQLite != "" {
+			database, err := newSQLiteResultWriter(cfg.SQLite, summary.RunID, start)
+			if err != nil {
+				return summary, err
+			}
+			formatOut = teeResultWriter{formatOut, database}
+		}
+		if cfg.ExportURL != "" {
+			export := exportOptions{url: cfg.ExportURL, batchSize: cfg.ExportBatchSize, retries: cfg.ExportRetries, backoff: time.Second}
+			formatOut = teeResultWriter{formatOut, newHTTPExporter(ctx, export, projected)}
+		}
+		if otlpEndpoint != "" {
+			otlp, err = newOTLPWriter(ctx, otlpEndpoint, summary.RunID)
+			if err != nil {
+				return summary, err
+			}
+			formatOut = teeResultWriter{formatOut, otlp}
+		}
+		metricsOut = newTableWriter(tableOpts, layout, formatOut)
+		if cfg.Pretty {
+			// The table on stderr is sorted by CPU on its own, then limited
+			prettyOpts := tableOptions{columns: tableOpts.columns, sortBy: layout.index("cpu"), desc: true, limit: tableOpts.limit}
+			pretty := newTableWriter(prettyOpts, layout, newPrettyResultWriter(os.Stderr, projected))
+			metricsOut = teeResultWriter{metricsOut, pretty}
+		}
+		if err := metricsOut.WriteHeader(); err != nil {
+			return summary, fmt.Errorf("writing metrics header: %w", err)
+		}
+		// The -peak-output file gets the same format and columns, without the
+		// rotation and other sinks
+		if cfg.PeakOutput != "" {
+			peakFile, err := os.Create(cfg.PeakOutput)
+			if err != nil {
+				return summary, fmt.Errorf("creating peak metrics file: %w", err)
+			}
+			peakOut = newTableWriter(tableOpts, layout, newWriter(peakFile, writerOpts))
+			if err := peakOut.WriteHeader(); err != nil {
+				return summary, fmt.Errorf("writing peak metrics header: %w", err)
+			}
+		}
+	}
+	// closeOutputs closes the metrics and -peak-output files, returning the
+	// first error.
+	closeOutputs := func() error {
+		err := metricsOut.Close()
+		if peakOut != nil {
+			if peakErr := peakOut.Close(); err == nil {
+				err = peakErr
+			}
+		}
+		return err
+	}
+
+	// finish reports the end of the run, returning the context error if a
+	// cancellation or -max-runtime cut it short, or the output error if a
+	// failed write did.
+	stats := &runStats{}
+	var retries *retryBudget
+	if cfg.RetryBudget > 0 {
+		retries = newRetryBudget(cfg.RetryBudget)
+	}
+	// exported names the file the rows went to, for the closing log lines
+	exported := func(kind string) string {
+		if cfg.Mode == modeStress {
+			return ", nothing recorded in -mode stress"
+		}
+		return fmt.Sprintf(". %s metrics exported to %s", kind, strings.Join(summary.OutputPaths, ", "))
+	}
+	finish := func(what string) error {
+		stats.log()
+		if summary.Truncated > 0 {
+			klog.Infof("Targets dropped by -max-pods-per-namespace: %d", summary.Truncated)
+		}
+		for _, timing := range summary.NamespaceTimings {
+			klog.Infof("Namespace %s: %d targets in %s", timing.Namespace, timing.Targets, timing.Duration.Round(time.Millisecond))
+		}
+		if waste != nil {
+			summary.Wasteful = waste.worst()
+			logWaste(summary.Wasteful, quantities)
+		}
+		if alerts != nil {
+			if err := alerts.send(summary.RunID); err != nil {
+				klog.Errorf("Error sending %d threshold breaches to -alert-webhook: %v", len(alerts.breaches), err)
+			} else if len(alerts.breaches) > 0 {
+				klog.Infof("Sent %d threshold breaches to -alert-webhook", len(alerts.breaches))
+			}
+		}
+		if prices.enabled() {
+			klog.Infof("Estimated cost over %s: %.2f", cfg.CostDuration, summary.EstimatedCost)
+		}
+		if previous != nil && ctx.Err() == nil {
+			for _, result := range previous.missing() {
+				if anon != nil {
+					anon.apply(result)
+				}
+				if err := metricsOut.WriteRow(result); err != nil {
+					klog.Errorf("Error writing metrics row: %v", err)
+				}
+			}
+		}
+		if anon != nil {
+			if err := anon.writeMapping(cfg.AnonymizeMap); err != nil {
+				klog.Errorf("Error writing anonymize map: %v", err)
+			} else {
+				klog.Infof("Anonymized names mapped in %s", cfg.AnonymizeMap)
+			}
+		}
+		summary.SamplesAttempted = stats.samplesAttempted.Load()
+		summary.SamplesSuccessful = stats.samplesSuccessful.Load()
+		summary.APIErrors = stats.apiErrors.Load()
+		summary.Disappeared = stats.disappeared.Load()
+		summary.TimedOut = stats.timedOut.Load()
+		summary.TooYoung = stats.tooYoung.Load()
+		summary.ZeroUsage = stats.zeroUsage.Load()
+		summary.Failures = stats.failureCounts()
+		if retries != nil {
+			summary.RetriesSkipped = retries.skipped.Load()
+			if summary.RetriesSkipped > 0 {
+				klog.Infof("Retries skipped after -retry-budget %d ran out: %d", cfg.RetryBudget, summary.RetriesSkipped)
+			}
+		}
+		if peakOut != nil {
+			klog.Infof("Peak metrics exported to %s", summary.PeakOutputPath)
+		}
+		if outputErr != nil {
+			klog.Errorf("Stopped after failing to write to %s, %s measured since were not recorded", summary.OutputPath, what)
+			closeOutputs()
+			return fmt.Errorf("writing metrics file: %w", outputErr)
+		}
+		if ctx.Err() == context.Canceled && cfg.Watch {
+			klog.Infof("Stopped watching %s%s", what, exported("Average"))
+			if err := closeOutputs(); err != nil {
+				return fmt.Errorf("writing metrics file: %w", err)
+			}
+			return nil
+		}
+		if ctx.Err() == context.Canceled {
+			klog.Warningf("Interrupted before all %s were stressed%s", what, exported("Partial"))
+			if err := closeOutputs(); err != nil {
+				klog.Errorf("Error writing metrics file: %v", err)
+			}
+			return ctx.Err()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			klog.Warningf("Stopped after -max-runtime %s before all %s were stressed%s", cfg.MaxRuntime, what, exported("Partial"))
+			if err := closeOutputs(); err != nil {
+				klog.Errorf("Error writing metrics file: %v", err)
+			}
+			return ctx.Err()
+		}
+		if err := closeOutputs(); err != nil {
+			return fmt.Errorf("writing metrics file: %w", err)
+		}
+		klog.Infof("All %s stressed%s", what, exported("Average"))
+		return nil
+	}
+
+	var hpas *hpaLookup
+	if cfg.HPA {
+		hpas = newHPALookup(clientset, timeouts)
+	}
+
+	// With -watch the same pods are read every cycle, so pod Gets come from
+	// an informer cache
+	podCache := newPodLookup(ctx, clientset, timeouts, cfg.Watch)
+	defer podCache.close()
+	podSampler := &sampler{pods: podCache, sources: sources, refreshPod: cfg.RefreshPod, waitForMetrics: cfg.WaitForMetrics, retries: retries,
+		burstDuration: cfg.BurstDuration, burstInterval: cfg.BurstInterval, trendDuration: cfg.TrendDuration, trendInterval: cfg.TrendInterval, stats: stats,
+		excludeContainers: excludedContainers(cfg.ExcludeContainers, cfg.IncludeSystemContainers), containerPattern: containerPattern, timeouts: timeouts}
+	if cfg.IncludeTerminated {
+		podSampler.terminated = lifetimeSource{newPrometheusSource(cfg.PrometheusURL)}
+	}
+	var podStressor *stressor
+	if stressCommand != nil {
+		podStressor = &stressor{clientset: clientset, config: config, command: stressCommand, duration: cfg.StressDuration, memMB: cfg.StressMemMB, timeouts: timeouts,
+			nodeSlots: newNodeSlots(cfg.MaxStressPerNode), verify: verifyStress, readUsage: podSampler.containerUsage}
+	}
+	// startStress runs the stress command in the pod while it is sampled,
+	// returning a func that stops it and the -stress-min-delta check.
+	startStress := func(ctx context.Context, pod *v1.Pod, container string) (func(), *StressCheck, error) {
+		if podStressor == nil {
+			return func() {}, nil, nil
+		}
+		if container == "" {
+			container = cfg.Container
+		}
+		return podStressor.start(ctx, pod, container)
+	}
+	// measure samples the pod into usage, or in -mode stress only holds it
+	// under load for -stress-duration
+	measure := func(ctx context.Context, pod *v1.Pod, usage *podUsage) {
+		if cfg.Mode == modeStress {
+			sleepContext(ctx, cfg.StressDuration)
+			return
+		}
+		samplesBefore := usage.numContainers
+		usage.lastErr = nil
+		podSampler.samplePod(ctx, pod, usage)
+		if usage.numContainers == samplesBefore && usage.lastErr != nil {
+			stats.fail(classifyFailure(usage.lastErr, failureMetricsUnavailable))
+		}
+	}
+	// podContext bounds the time spent on one pod, its stress and all its
+	// samples, by -pod-timeout
+	podContext := func() (context.Context, context.CancelFunc) {
+		if cfg.PodTimeout > 0 {
+			return context.WithTimeout(ctx, cfg.PodTimeout)
+		}
+		return context.WithCancel(ctx)
+	}
+	// timedOut reports, and logs, whether -pod-timeout cut a pod short, as
+	// opposed to the whole run ending, keeping its samples so far
+	timedOut := func(podCtx context.Context, pod *v1.Pod) bool {
+		if ctx.Err() != nil || podCtx.Err() != context.DeadlineExceeded {
+			return false
+		}
+		klog.Warningf("Pod %s/%s hit -pod-timeout %s, keeping the samples taken so far", pod.Namespace, pod.Name, cfg.PodTimeout)
+		stats.timedOut.Add(1)
+		stats.fail(failureTimeout)
+		return true
+	}
+
+	// tooYoung reports, and logs, whether a pod is younger than -min-age
+	tooYoung := func(pod *v1.Pod) bool {
+		if cfg.MinAge <= 0 {
+			return false
+		}
+		age := podAge(pod)
+		if age >= cfg.MinAge {
+			return false
+		}
+		klog.Infof("Skipping pod: %s in namespace: %s, it is %s old, younger than -min-age %s", pod.Name, pod.Namespace, age.Round(time.Second), cfg.MinAge)
+		stats.tooYoung.Add(1)
+		return true
+	}
+
+	emit := func(result *PodResult) {
+		if podUIDs != nil && result.Pod != "" {
+			key := result.Namespace + "/" + result.Pod
+			if previous, ok := podUIDs[key]; ok && previous != result.UID {
+				klog.Infof("Pod %s was replaced since the last cycle, UID %s is now %s", key, previous, result.UID)
+			}
+			podUIDs[key] = result.UID
+		}
+		if friendly, ok := namespaceMap[result.Namespace]; ok {
+			result.Namespace = friendly
+		}
+		if previous != nil {
+			previous.match(result)
+		}
+		if anon != nil {
+			anon.apply(result)
+		}
+		if err := metricsOut.WriteRow(result); err != nil {
+			klog.Errorf("Error writing metrics row: %v", err)
+			var writeErr *outputWriteError
+			if errors.As(err, &writeErr) && outputErr == nil {
+				outputErr = err
+				stopRun()
+			}
+			return
+		}
+		if peakOut != nil && result.peak != nil {
+			peak := result.peak
+			if friendly, ok := namespaceMap[peak.Namespace]; ok {
+				peak.Namespace = friendly
+			}
+			if anon != nil {
+				anon.apply(peak)
+			}
+			if err := peakOut.WriteRow(peak); err != nil {
+				klog.Errorf("Error writing peak metrics row: %v", err)
+				var writeErr *outputWriteError
+				if errors.As(err, &writeErr) && outputErr == nil {
+					outputErr = err
+					stopRun()
+				}
+			}
+		}
+		summary.Rows++
+		if waste != nil {
+			waste.add(result)
+		}
+		if alerts != nil {
+			alerts.add(result)
+		}
+		if failOn != nil {
+			if value, ok := failOn.match(result); ok {
+				name := result.Pod
+				if name == "" {
+					name = result.Owner
+				}
+				key := FailOnMatch{Namespace: result.Namespace, Name: name, Container: result.Container}
+				if i, ok := failOnIndex[key]; ok {
+					summary.FailOnMatches[i].Value = value
+				} else {
+					failOnIndex[key] = len(summary.FailOnMatches)
+					match := key
+					match.Value = value
+					summary.FailOnMatches = append(summary.FailOnMatches, match)
+				}
+			}
+		}
+		if prices.enabled() {
+			summary.EstimatedCost += prices.cost(result)
+		}
+	}
+
+	// With -ema-alpha, each row reports its series' moving average
+	if cfg.EMAAlpha != 0 {
+		ema := newEMASmoother(cfg.EMAAlpha)
+		emitSmoothed := emit
+		emit = func(result *PodResult) {
+			ema.smooth(result)
+			emitSmoothed(result)
+		}
+	}
+
+	// With -per-container, each row is split into its containers' rows
+	if cfg.PerContainer {
+		emitRow := emit
+		emit = func(result *PodResult) {
+			for _, row := range containerRows(result) {
+				emitRow(row)
+			}
+		}
+	}
+
+	// Without -report-zero, rows measured at exactly zero CPU and memory are
+	// dropped, before -ema-alpha smooths them
+	if !cfg.ReportZero {
+		emitNonZero := emit
+		emit = func(result *PodResult) {
+			if result.AvgCPUMilli == 0 && result.AvgMemoryBytes == 0 {
+				name := result.Pod
+				if name == "" {
+					name = result.Owner
+				}
+				klog.V(1).Infof("Omitting %s/%s, its usage is zero", result.Namespace, name)
+				stats.zeroUsage.Add(1)
+				return
+			}
+			emitNonZero(result)
+		}
+	}
+
+	// With -group-by-label, rows are collected per cycle and emitted merged
+	var groups *labelGroups
+	if cfg.GroupByLabel != "" {
+		groups = newLabelGroups(cfg.GroupByLabel)
+	}
+
+	// Measure each deployment across all of its current pods, or each pod
+	what := "pods"
+	var work func(Target) *PodResult
+	var describe func(Target) string
+	if cfg.Deployments {
+		what = "deployments"
+		describe = func(target Target) string {
+			return fmt.Sprintf("deployment %s/%s", target.Namespace, target.Name)
+		}
+		work = func(target Target) *PodResult {
+			namespace, deploymentName := target.Namespace, target.Name
+
+			klog.Infof("Stressing deployment: %s in namespace: %s", deploymentName, namespace)
+
+			pods, err := deploymentPods(ctx, clientset, namespace, deploymentName, timeouts)
+			if err != nil {
+				klog.Errorf("Error listing deployment pods: %v", err)
+				stats.apiErrors.Add(1)
+				stats.fail(classifyFailure(err, failureOther))
+				return nil
+			}
+			if len(pods) == 0 {
+				klog.Warningf("No pods found for deployment: %s in namespace: %s", deploymentName, namespace)
+				return nil
+			}
+
+			var usage podUsage
+			podNames := make([]string, 0, len(pods))
+			var uids []string
+			var stressCheck *StressCheck // the first failed check, else any
+			var anyTimedOut bool
+			var youngest time.Duration
+			for i := range pods {
+				if cfg.RequireReady && !podReady(&pods[i]) {
+					klog.Warningf("Skipping pod: %s in namespace: %s, it is not ready", pods[i].Name, namespace)
+					continue
+				}
+				if tooYoung(&pods[i]) {
+					continue
+				}
+				if age := podAge(&pods[i]); youngest == 0 || age < youngest {
+					youngest = age
+				}
+				klog.Infof("Stressing pod: %s in namespace: %s", pods[i].Name, namespace)
+				podCtx, cancel := podContext()
+				stopStress, check, err := startStress(podCtx, &pods[i], "")
+				if err != nil {
+					cancel()
+					klog.Errorf("Error starting stress command: %v", err)
+					stats.fail(classifyFailure(err, failureExecFailed))
+					continue
+				}
+				if check != nil && (stressCheck == nil || !check.Passed) {
+					stressCheck = check
+				}
+				measure(podCtx, &pods[i], &usage)
+				stopStress()
+				if timedOut(podCtx, &pods[i]) {
+					anyTimedOut = true
+				}
+				cancel()
+				podNames = append(podNames, pods[i].Name)
+				uids = append(uids, string(pods[i].UID))
+			}
+			if len(podNames) == 0 {
+				klog.Warningf("No pods left to measure for deployment: %s in namespace: %s after -require-ready and -min-age", deploymentName, namespace)
+				return nil
+			}
+			if cfg.Mode == modeStress {
+				klog.Infof("Finished stressing deployment: %s in namespace: %s", deploymentName, namespace)
+				return nil
+			}
+
+			result := &PodResult{Namespace: namespace, Owner: deploymentName, OwnerKind: "Deployment", Selector: target.Selector}
+			result.Image, result.ImageID = appContainerImage(&pods[0], cfg.Container)
+			result.QOSClass = string(pods[0].Status.QOSClass)
+			result.Labels = pods[0].Labels
+			result.StressCheck = stressCheck
+			result.UID = strings.Join(uids, "+")
+			result.Age = youngest
+			usage.fill(result, agg)
+			if anyTimedOut && result.Status == "" {
+				result.Status = statusTimedOut
+			}
+			if customMetrics != nil {
+				result.CustomMetric = customMetrics.averageColumn(namespace, podNames...)
+			}
+			if hpas != nil {
+				result.HPA = hpas.status(ctx, namespace, "Deployment", deploymentName)
+			}
+			if peakOut != nil {
+				result.peak = usage.peakOf(result)
+			}
+
+			klog.Infof("Finished stressing deployment: %s in namespace: %s", deploymentName, namespace)
+			return result
+		}
+	} else {
+		describe = func(target Target) string {
+			return fmt.Sprintf("pod %s/%s", target.Namespace, target.Name)
+		}
+		work = func(target Target) *PodResult {
+			podName, namespace := target.Name, target.Namespace
+
+			klog.Infof("Stressing pod: %s in namespace: %s", podName, namespace)
+			podCtx, cancel := podContext()
+			defer cancel()
+
+			// Get the pod from Kubernetes
+			pod, err := podCache.get(podCtx, namespace, podName)
+			if err != nil {
+				klog.Errorf("Error getting pod: %v", err)
+				stats.apiErrors.Add(1)
+				stats.fail(classifyFailure(err, failureOther))
+				return nil
+			}
+
+			// Finished pods can't be stressed and have no live metrics; with
+			// -include-terminated their lifetime peaks come from Prometheus
+			terminated := podTerminated(pod)
+			if terminated && !cfg.IncludeTerminated {
+				klog.Infof("Skipping pod: %s in namespace: %s, it has %s (see -include-terminated)", podName, namespace, pod.Status.Phase)
+				return nil
+			}
+			if terminated && cfg.Mode == modeStress {
+				klog.Infof("Skipping pod: %s in namespace: %s, it has %s and can't be stressed", podName, namespace, pod.Status.Phase)
+				return nil
+			}
+
+			if cfg.RequireReady && !terminated && !podReady(pod) {
+				klog.Warningf("Skipping pod: %s in namespace: %s, it is not ready", podName, namespace)
+				return nil
+			}
+			if tooYoung(pod) {
+				return nil
+			}
+
+			// Resolve the workload that owns the pod, falling back to the pod name
+			owner, ownerKind := pod.Name, ownerKindSkipped
+			if cfg.ResolveOwner {
+				ownerCtx, cancelOwner := timeouts.forGet(podCtx)
+				owner, ownerKind = resolveOwner(ownerCtx, clientset, pod)
+				cancelOwner()
+			}
+			if owner == "" {
+				klog.Warningf("No deployment found for pod: %s in namespace: %s", podName, namespace)
+				return nil
+			}
+
+			result := &PodResult{Namespace: namespace, Pod: podName, Owner: owner, OwnerKind: ownerKind, Selector: target.Selector, UID: string(pod.UID), Age: podAge(pod)}
+			container := target.Container
+			if container == "" {
+				container = cfg.Container
+			}
+			result.Image, result.ImageID = appContainerImage(pod, container)
+			result.QOSClass = string(pod.Status.QOSClass)
+			result.Labels = pod.Labels
+
+			var usage podUsage
+			if terminated {
+				podSampler.sampleTerminated(podCtx, pod, &usage)
+				if usage.numContainers == 0 && usage.lastErr != nil {
+					stats.fail(classifyFailure(usage.lastErr, failureMetricsUnavailable))
+				}
+			} else {
+				stopStress, check, err := startStress(podCtx, pod, target.Container)
+				if err != nil {
+					klog.Errorf("Error starting stress command: %v", err)
+					stats.fail(classifyFailure(err, failureExecFailed))
+					return nil
+				}
+				result.StressCheck = check
+				measure(podCtx, pod, &usage)
+				stopStress()
+			}
+			podTimedOut := timedOut(podCtx, pod)
+			if cfg.Mode == modeStress {
+				klog.Infof("Finished stressing pod: %s in namespace: %s", podName, namespace)
+				return nil
+			}
+
+			// Calculate average metrics for the result
+			usage.fill(result, agg)
+			if podTimedOut && result.Status == "" {
+				result.Status = statusTimedOut
+			}
+			if customMetrics != nil {
+				result.CustomMetric = customMetrics.averageColumn(namespace, podName)
+			}
+			if hpas != nil {
+				result.HPA = hpas.status(ctx, namespace, ownerKind, owner)
+			}
+			if peakOut != nil {
+				result.peak = usage.peakOf(result)
+			}
+
+			klog.Infof("Finished stressing pod: %s in namespace: %s", podName, namespace)
+			return result
+		}
+	}
+
+	// Measure the targets once, or every -watch-interval until interrupted,
+	// idling while outside -active-window. A streamed input or listing is
+	// only read during the first cycle; later ones measure the targets it
+	// kept.
+	for cycle := 0; ; cycle++ {
+		if window != nil {
+			if idle := window.untilOpen(time.Now()); idle > 0 {
+				klog.Infof("Outside -active-window %s, idling for %s", cfg.ActiveWindow, idle.Round(time.Second))
+				if !sleepContext(ctx, idle) {
+					break
+				}
+			}
+		}
+		emitTo := emit
+		if groups != nil {
+			emitTo = groups.add
+		}
+		if cfg.ParallelNamespaces > 0 {
+			summary.NamespaceTimings = runByNamespace(ctx, targets, cfg.ParallelNamespaces, cfg.Concurrency, cfg.OrderBuffer, work, emitTo, describe)
+		} else {
+			var feed <-chan Target
+			if streamInput && cycle == 0 {
+				feed = streamCSVTargets(ctx, inputFile, cfg.Input, comment, cfg.Deployments, keep, &targets)
+			} else if streamList && cycle == 0 {
+				feed = listFeed
+			} else {
+				feed = sendTargets(ctx, targets)
+			}
+			runOrdered(ctx, feed, cfg.Concurrency, cfg.OrderBuffer, work, emitTo, describe)
+			if streaming && cycle == 0 && cfg.SampleRate < 1 && ctx.Err() == nil {
+				klog.Infof("Sampled %d of %d matched targets at -sample-rate %g (-seed %d)", len(targets), streamMatched, cfg.SampleRate, seed)
+			}
+		}
+		if groups != nil {
+			for _, result := range groups.flush() {
+				emit(result)
+			}
+		}
+		if otlp != nil {
+			otlp.endCycle()
+		}
+		if !cfg.Watch || !sleepContext(ctx, cfg.WatchInterval) {
+			break
+		}
+	}
+	err = finish(what)
+	if err == nil && len(summary.FailOnMatches) > 0 {
+		for _, match := range summary.FailOnMatches {
+			where := match.Namespace + "/" + match.Name
+			if match.Container != "" {
+				where += " container " + match.Container
+			}
+			klog.Errorf("%s matched -fail-on %s with %g", where, failOn.text, match.Value)
+		}
+		err = fmt.Errorf("%d pods or containers matched -fail-on %s: %w", len(summary.FailOnMatches), failOn.text, ErrFailOn)
+	}
+	return summary, err
+}
@@ -0,0 +1,511 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog"
+)
+
+// podUsage accumulates the container metrics samples taken for one pod, or
+// for all pods of a deployment when they are aggregated into one row.
+type podUsage struct {
+	cpuTotalMilli int64
+	memoryTotal   int64
+	numContainers int
+	cpuWindow     cpuRateAccumulator
+	freshMean     weightedMean
+	trend         memoryTrend
+
+	// containers holds per-container totals in the order first seen.
+	containers []*containerTotals
+	// sources lists the metrics sources that produced samples, in the order
+	// first used.
+	sources []string
+
+	// extended sums the pod-level network traffic during sampling and the
+	// final ephemeral storage usage of each pod, nil if no source reported
+	// them.
+	extended *ExtendedStats
+
+	// disappeared is set when a sampled pod was deleted or replaced before
+	// its samples were all taken.
+	disappeared bool
+	// lastErr is the last failed metrics read, to classify a pod none of
+	// whose samples succeeded.
+	lastErr error
+}
+
+// containerTotals accumulates one container's samples. In -deployments mode
+// the same container of every pod is summed together.
+type containerTotals struct {
+	name          string
+	cpuTotalMilli int64
+	memoryTotal   int64
+	samples       int
+	// cpuSamples and memorySamples keep every sample, in the order taken,
+	// for the -sample-strategy values other than mean.
+	cpuSamples    []int64
+	memorySamples []int64
+
+	// peakMemory is the highest memory sample and memoryLimit the limit of
+	// the pod it was taken from, 0 for no limit.
+	peakMemory  int64
+	memoryLimit int64
+	// cpuRequest and memoryRequest are the container's requests in
+	// millicores and bytes, 0 for none, and cpuLimit its CPU limit.
+	cpuRequest    int64
+	memoryRequest int64
+	cpuLimit      int64
+	// image and imageID are the container's from its status.
+	image, imageID string
+}
+
+// container returns the totals for the named container, adding them if new.
+func (u *podUsage) container(name string) *containerTotals {
+	for _, totals := range u.containers {
+		if totals.name == name {
+			return totals
+		}
+	}
+	totals := &containerTotals{name: name}
+	u.containers = append(u.containers, totals)
+	return totals
+}
+
+// sampler takes metrics samples for pods.
+type sampler struct {
+	pods *podLookup
+	// sources are tried in order for each pod; the first that answers is
+	// used for all of that pod's samples.
+	sources []metricsSource
+
+	// refreshPod re-fetches the pod before every sample, for workloads
+	// whose containers change mid-run. Otherwise the pod passed to
+	// samplePod is reused and only its metrics are fetched each time.
+	refreshPod bool
+
+	// waitForMetrics is how long to keep retrying a pod whose metrics are
+	// NotFound, as they are for up to ~30s after it starts, before counting
+	// the sample as failed. Zero disables retrying. Each retry comes out
+	// of retries, if set.
+	waitForMetrics time.Duration
+	retries        *retryBudget
+
+	// burstDuration and burstInterval configure the dense sampling phase
+	// before the steady samples; a zero value of either disables it.
+	burstDuration time.Duration
+	burstInterval time.Duration
+
+	// trendDuration, if set, replaces the steady samples with one every
+	// trendInterval for that long, to fit a memory trend to.
+	trendDuration time.Duration
+	trendInterval time.Duration
+
+	// excludeContainers are the container names not sampled, see
+	// excludedContainers.
+	excludeContainers map[string]bool
+	// containerPattern, if set, is the -container-regex sampled container
+	// names must match.
+	containerPattern *regexp.Regexp
+
+	// timeouts bounds the metrics reads.
+	timeouts callTimeouts
+
+	// terminated reads finished pods for -include-terminated, nil without
+	// it.
+	terminated metricsSource
+
+	stats *runStats
+}
+
+// metricsRetryInterval is the pause between -wait-for-metrics retries.
+const metricsRetryInterval = 2 * time.Second
+
+// podSampling is the state samplePod carries between the samples of a pod.
+type podSampling struct {
+	pod *v1.Pod
+	// source is the pod's metrics source, picked on the first success.
+	source    metricsSource
+	waitUntil time.Time
+	// firstPod and lastPod are the first and latest pod-level readings.
+	firstPod, lastPod *podReading
+}
+
+// samplePod takes the configured number of metrics samples for a pod and
+// adds them to usage. With -burst-duration, samples are first taken every
+// -burst-interval to catch startup spikes, then at the steady cadence.
+func (s *sampler) samplePod(ctx context.Context, pod *v1.Pod, usage *podUsage) {
+	state := &podSampling{pod: pod, waitUntil: time.Now().Add(s.waitForMetrics)}
+	defer func() {
+		if state.lastPod != nil {
+			usage.addPodReadings(state.firstPod, state.lastPod)
+		}
+	}()
+
+	if s.burstDuration > 0 && s.burstInterval > 0 {
+		burstEnd := time.Now().Add(s.burstDuration)
+		for time.Now().Before(burstEnd) {
+			if !s.sampleOnce(ctx, state, usage) || !sleepContext(ctx, s.burstInterval) {
+				return
+			}
+		}
+	}
+
+	if s.trendDuration > 0 {
+		trendEnd := time.Now().Add(s.trendDuration)
+		for {
+			if !s.sampleOnce(ctx, state, usage) || !time.Now().Add(s.trendInterval).Before(trendEnd) || !sleepContext(ctx, s.trendInterval) {
+				return
+			}
+		}
+	}
+
+	// Stress the pod (adjust the number of iterations as needed)
+	for i := 0; i < 5; i++ {
+		if !s.sampleOnce(ctx, state, usage) {
+			return
+		}
+
+		// Wait for some time to stress the pod, returning early on shutdown
+		if !sleepContext(ctx, 1*time.Second) { // Adjust the duration as needed
+			return
+		}
+	}
+}
+
+// sampleTerminated takes the one reading of a finished pod, its lifetime
+// peaks from s.terminated: its live metrics are gone and won't change.
+func (s *sampler) sampleTerminated(ctx context.Context, pod *v1.Pod, usage *podUsage) {
+	samplesBefore := usage.numContainers
+	s.sampleOnce(ctx, &podSampling{pod: pod, source: s.terminated}, usage)
+	if usage.numContainers > samplesBefore {
+		usage.addSource(s.terminated.name())
+	}
+}
+
+// sampleOnce takes one metrics sample of the pod and adds it to usage. It
+// returns false if ctx is done or the pod disappeared, which a NotFound
+// from the pod or its metrics is confirmed against.
+func (s *sampler) sampleOnce(ctx context.Context, state *podSampling, usage *podUsage) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	namespace, podName := state.pod.Namespace, state.pod.Name
+
+	if s.refreshPod {
+		refreshed, err := s.pods.get(ctx, namespace, podName)
+		if apierrors.IsNotFound(err) || (err == nil && refreshed.UID != state.pod.UID) {
+			s.disappeared(usage, state.pod)
+			return false
+		}
+		if err != nil {
+			klog.Errorf("Error getting pod: %v", err)
+			s.stats.apiErrors.Add(1)
+			return true
+		}
+		state.pod = refreshed
+	}
+	pod := state.pod
+
+	// Fetch container metrics, picking the pod's source on first success
+	statuses := make([]v1.ContainerStatus, 0, len(pod.Status.ContainerStatuses))
+	for _, status := range pod.Status.ContainerStatuses {
+		if s.sampled(status.Name) {
+			statuses = append(statuses, status)
+		}
+	}
+	s.stats.samplesAttempted.Add(int64(len(statuses)))
+	var reading *usageReading
+	var err error
+	for {
+		if state.source != nil {
+			reading, err = s.read(ctx, state.source, pod)
+		} else {
+			state.source, reading, err = s.firstAvailable(ctx, pod)
+			if state.source != nil {
+				usage.addSource(state.source.name())
+			}
+		}
+		if !apierrors.IsNotFound(err) || !time.Now().Before(state.waitUntil) {
+			break
+		}
+		if !s.retries.take() {
+			break
+		}
+		klog.V(2).Infof("Metrics not yet available for pod %s/%s, retrying: %v", namespace, podName, err)
+		if !sleepContext(ctx, metricsRetryInterval) {
+			return false
+		}
+	}
+	if apierrors.IsNotFound(err) && s.podGone(ctx, pod) {
+		s.disappeared(usage, pod)
+		return false
+	}
+	if err != nil {
+		klog.Errorf("Error getting pod metrics: %v", err)
+		s.stats.apiErrors.Add(1)
+		usage.lastErr = err
+		return true
+	}
+
+	// A reading without containers isn't populated yet; the attempted
+	// samples stay unsuccessful rather than counting as zero usage
+	if len(reading.containers) == 0 {
+		klog.V(2).Infof("Metrics for pod %s/%s have no containers yet, skipping sample", namespace, podName)
+		return true
+	}
+	// A repeat of the last scrape isn't a new sample, attempted or not
+	if reading.repeat {
+		klog.V(2).Infof("Metrics for pod %s/%s haven't been scraped since the last sample, skipping it", namespace, podName)
+		s.stats.samplesAttempted.Add(-int64(len(statuses)))
+		return true
+	}
+
+	if reading.pod != nil {
+		if state.firstPod == nil {
+			state.firstPod = reading.pod
+		}
+		state.lastPod = reading.pod
+	}
+
+	// Calculate metrics for each container
+	var readingMemory int64
+	var readingFound bool
+	for _, containerMetric := range statuses {
+		container, found := reading.container(containerMetric.Name)
+		if !found {
+			continue
+		}
+		readingMemory = addClamped(readingMemory, container.memoryBytes)
+		readingFound = true
+
+		usage.cpuTotalMilli = addClamped(usage.cpuTotalMilli, container.cpuMilli)
+		usage.memoryTotal = addClamped(usage.memoryTotal, container.memoryBytes)
+		usage.numContainers++
+		s.stats.samplesSuccessful.Add(1)
+		totals := usage.container(containerMetric.Name)
+		totals.cpuTotalMilli = addClamped(totals.cpuTotalMilli, container.cpuMilli)
+		totals.memoryTotal = addClamped(totals.memoryTotal, container.memoryBytes)
+		totals.samples++
+		if totals.samples == 1 {
+			totals.cpuRequest = containerCPURequest(pod, containerMetric.Name)
+			totals.memoryRequest = containerMemoryRequest(pod, containerMetric.Name)
+			totals.cpuLimit = containerCPULimit(pod, containerMetric.Name)
+			totals.image, totals.imageID = containerMetric.Image, containerMetric.ImageID
+		}
+		totals.cpuSamples = append(totals.cpuSamples, container.cpuMilli)
+		totals.memorySamples = append(totals.memorySamples, container.memoryBytes)
+		if totals.samples == 1 || container.memoryBytes > totals.peakMemory {
+			totals.peakMemory = container.memoryBytes
+			totals.memoryLimit = containerMemoryLimit(pod, containerMetric.Name)
+		}
+		usage.cpuWindow.add(namespace, podName, reading, container)
+		weight := freshnessWeight(reading.timestamp, time.Now(), reading.window)
+		usage.freshMean.add(weight, container.cpuMilli, container.memoryBytes)
+	}
+	if readingFound {
+		at := reading.timestamp
+		if at.IsZero() {
+			at = time.Now()
+		}
+		usage.trend.add(at, readingMemory)
+	}
+	return true
+}
+
+// podGone reports whether the pod was deleted, or replaced by a new pod of
+// the same name, since it was fetched.
+func (s *sampler) podGone(ctx context.Context, pod *v1.Pod) bool {
+	current, err := s.pods.get(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return apierrors.IsNotFound(err)
+	}
+	return current.UID != pod.UID
+}
+
+// disappeared records that the pod went away mid-sampling, keeping the
+// samples taken so far.
+func (s *sampler) disappeared(usage *podUsage, pod *v1.Pod) {
+	klog.Warningf("Pod %s/%s disappeared while sampling, keeping %d samples", pod.Namespace, pod.Name, usage.numContainers)
+	usage.disappeared = true
+	s.stats.disappeared.Add(1)
+}
+
+// sampled reports whether the named container is sampled: not excluded,
+// and matching -container-regex if set.
+func (s *sampler) sampled(container string) bool {
+	if s.excludeContainers[container] {
+		return false
+	}
+	return s.containerPattern == nil || s.containerPattern.MatchString(container)
+}
+
+// sleepContext waits for d, returning false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// read takes one reading of the pod from source, bounded by -get-timeout.
+func (s *sampler) read(ctx context.Context, source metricsSource, pod *v1.Pod) (*usageReading, error) {
+	ctx, cancel := s.timeouts.forGet(ctx)
+	defer cancel()
+	return source.read(ctx, pod)
+}
+
+// firstAvailable reads the pod from each source in turn and returns the
+// first that succeeds. If all fail, the last error is returned.
+func (s *sampler) firstAvailable(ctx context.Context, pod *v1.Pod) (metricsSource, *usageReading, error) {
+	var lastErr error
+	for _, source := range s.sources {
+		reading, err := s.read(ctx, source, pod)
+		if err == nil {
+			return source, reading, nil
+		}
+		if len(s.sources) > 1 {
+			klog.V(2).Infof("Metrics source %s unavailable for pod %s/%s: %v", source.name(), pod.Namespace, pod.Name, err)
+		}
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+// containerUsage takes a single reading of one container's usage from the
+// first available source.
+func (s *sampler) containerUsage(ctx context.Context, pod *v1.Pod, container string) (containerReading, error) {
+	_, reading, err := s.firstAvailable(ctx, pod)
+	if err != nil {
+		return containerReading{}, err
+	}
+	usage, found := reading.container(container)
+	if !found {
+		return containerReading{}, fmt.Errorf("no metrics for container %s", container)
+	}
+	return usage, nil
+}
+
+// addPodReadings adds a pod's network traffic between its first and last
+// reading, and its last ephemeral storage usage.
+func (u *podUsage) addPodReadings(first, last *podReading) {
+	if u.extended == nil {
+		u.extended = &ExtendedStats{}
+	}
+	if last.networkRxBytes >= first.networkRxBytes && last.networkTxBytes >= first.networkTxBytes {
+		u.extended.NetworkRxBytes = addClamped(u.extended.NetworkRxBytes, clampedInt64(last.networkRxBytes-first.networkRxBytes))
+		u.extended.NetworkTxBytes = addClamped(u.extended.NetworkTxBytes, clampedInt64(last.networkTxBytes-first.networkTxBytes))
+	}
+	u.extended.EphemeralStorageBytes = addClamped(u.extended.EphemeralStorageBytes, clampedInt64(last.ephemeralStorageBytes))
+}
+
+// addSource records that a metrics source produced samples.
+func (u *podUsage) addSource(name string) {
+	for _, source := range u.sources {
+		if source == name {
+			return
+		}
+	}
+	u.sources = append(u.sources, name)
+}
+
+// aggregation controls how fill collapses samples into reported figures.
+type aggregation struct {
+	strategy string // one of the -sample-strategy values
+	weighted bool
+	cpuRate  bool
+}
+
+// fill collapses the accumulated samples into the usage figures of result.
+func (u *podUsage) fill(result *PodResult, agg aggregation) {
+	result.Samples = u.numContainers
+	result.Source = strings.Join(u.sources, "+")
+	result.Extended = u.extended
+	if u.disappeared {
+		result.Status = statusDisappeared
+	}
+	result.AvgCPUMilli, result.AvgMemoryBytes = u.averages(agg)
+	if trend, ok := u.trend.classify(); ok {
+		result.Trend = trend
+	}
+	result.Containers = make([]ContainerUsage, 0, len(u.containers))
+	for _, totals := range u.containers {
+		result.Containers = append(result.Containers, ContainerUsage{
+			Name:               totals.name,
+			Samples:            totals.samples,
+			AvgCPUMilli:        collapse(agg.strategy, totals.cpuSamples),
+			AvgMemoryBytes:     collapse(agg.strategy, totals.memorySamples),
+			PeakMemoryBytes:    totals.peakMemory,
+			MemoryLimitBytes:   totals.memoryLimit,
+			CPURequestMilli:    totals.cpuRequest,
+			MemoryRequestBytes: totals.memoryRequest,
+			CPULimitMilli:      totals.cpuLimit,
+			Image:              totals.image,
+			ImageID:            totals.imageID,
+		})
+	}
+}
+
+// peakOf copies result with its usage figures collapsed to the highest
+// samples, for -peak-output.
+func (u *podUsage) peakOf(result *PodResult) *PodResult {
+	peak := *result
+	u.fill(&peak, aggregation{strategy: strategyMax})
+	return &peak
+}
+
+// averages collapses the accumulated samples into the reported CPU
+// (millicores) and memory (bytes) figures.
+func (u *podUsage) averages(agg aggregation) (int64, int64) {
+	var avgCPUMilli int64
+	var avgMemoryBytes int64
+
+	if u.numContainers > 0 {
+		avgCPUMilli = u.cpuTotalMilli / int64(u.numContainers)
+		avgMemoryBytes = u.memoryTotal / int64(u.numContainers)
+	}
+	if agg.strategy != strategyMean {
+		avgCPUMilli, avgMemoryBytes = u.collapsed(agg.strategy)
+	}
+	if agg.weighted {
+		if cpuMilli, memoryBytes, ok := u.freshMean.mean(); ok {
+			avgCPUMilli, avgMemoryBytes = cpuMilli, memoryBytes
+		}
+	}
+	if agg.cpuRate {
+		if rateMilli, ok := u.cpuWindow.rate(); ok {
+			avgCPUMilli = rateMilli
+		} else {
+			klog.V(2).Info("No metrics window reported, using averaged CPU samples")
+		}
+	}
+	return avgCPUMilli, avgMemoryBytes
+}
+
+// collapsed applies a median, max or last strategy across containers. Median
+// and max pool every container sample like the mean does; last averages the
+// last sample of each container, since they are taken together.
+func (u *podUsage) collapsed(strategy string) (int64, int64) {
+	var cpuSamples, memorySamples []int64
+	for _, totals := range u.containers {
+		if strategy == strategyLast {
+			cpuSamples = append(cpuSamples, totals.cpuSamples[len(totals.cpuSamples)-1])
+			memorySamples = append(memorySamples, totals.memorySamples[len(totals.memorySamples)-1])
+			continue
+		}
+		cpuSamples = append(cpuSamples, totals.cpuSamples...)
+		memorySamples = append(memorySamples, totals.memorySamples...)
+	}
+	if strategy == strategyLast {
+		return collapse(strategyMean, cpuSamples), collapse(strategyMean, memorySamples)
+	}
+	return collapse(strategy, cpuSamples), collapse(strategy, memorySamples)
+}
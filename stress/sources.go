@@ -0,0 +1,419 @@
+package stress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Metrics sources accepted by -source.
+const (
+	sourceMetricsServer = "metrics-server"
+	sourceKubelet       = "kubelet"
+	sourcePrometheus    = "prometheus"
+	sourceAuto          = "auto"
+)
+
+// usageReading is one reading of a pod's container usage.
+type usageReading struct {
+	timestamp time.Time
+	// window is the interval the source averaged the reading over, zero if
+	// it doesn't report one.
+	window     time.Duration
+	containers []containerReading
+	// pod holds pod-level network and filesystem figures, for sources that
+	// report them, and is nil otherwise.
+	pod *podReading
+	// repeat is set when the source knows the reading is the same scrape as
+	// its previous one of the pod, which isn't sampled again.
+	repeat bool
+}
+
+// podReading is the pod-level part of a usageReading.
+type podReading struct {
+	// networkRxBytes and networkTxBytes are cumulative counters.
+	networkRxBytes uint64
+	networkTxBytes uint64
+	// ephemeralStorageBytes is the pod's current ephemeral storage usage.
+	ephemeralStorageBytes uint64
+}
+
+// containerReading is one container's usage within a usageReading.
+type containerReading struct {
+	name        string
+	cpuMilli    int64
+	memoryBytes int64
+	// cpuCumulativeNanos is the container's total CPU time, for sources that
+	// expose the counter, and zero otherwise.
+	cpuCumulativeNanos uint64
+}
+
+// container returns the reading for the named container, if present.
+func (r *usageReading) container(name string) (containerReading, bool) {
+	for _, c := range r.containers {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return containerReading{}, false
+}
+
+// metricsSource reads the current usage of a pod's containers.
+type metricsSource interface {
+	name() string
+	read(ctx context.Context, pod *v1.Pod) (*usageReading, error)
+}
+
+// newMetricsSources returns the sources to try for each pod, in order, for
+// a -source value. "auto" tries metrics-server, then the kubelet summary API,
+// then Prometheus if a URL is configured.
+func newMetricsSources(source string, clientset kubernetes.Interface, metricsClient versioned.Interface, prometheusURL string) ([]metricsSource, error) {
+	metricsServer := &metricsServerSource{metricsClient: metricsClient}
+	kubelet := &kubeletSource{clientset: clientset}
+	var prometheus metricsSource
+	if prometheusURL != "" {
+		prometheus = newPrometheusSource(prometheusURL)
+	}
+
+	switch source {
+	case sourceMetricsServer:
+		return []metricsSource{metricsServer}, nil
+	case sourceKubelet:
+		return []metricsSource{kubelet}, nil
+	case sourcePrometheus:
+		if prometheus == nil {
+			return nil, fmt.Errorf("-source %s requires -prometheus-url", sourcePrometheus)
+		}
+		return []metricsSource{prometheus}, nil
+	case sourceAuto:
+		sources := []metricsSource{metricsServer, kubelet}
+		if prometheus != nil {
+			sources = append(sources, prometheus)
+		}
+		return sources, nil
+	default:
+		return nil, fmt.Errorf("unknown source %q, want %s, %s, %s or %s", source, sourceMetricsServer, sourceKubelet, sourcePrometheus, sourceAuto)
+	}
+}
+
+// metricsServerSource reads PodMetrics from the metrics.k8s.io API.
+type metricsServerSource struct {
+	metricsClient versioned.Interface
+}
+
+func (s *metricsServerSource) name() string {
+	return sourceMetricsServer
+}
+
+func (s *metricsServerSource) read(ctx context.Context, pod *v1.Pod) (*usageReading, error) {
+	podMetrics, err := s.metricsClient.MetricsV1beta1().PodMetricses(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	reading := &usageReading{timestamp: podMetrics.Timestamp.Time, window: podMetrics.Window.Duration}
+	for _, container := range podMetrics.Containers {
+		cpuUsage := container.Usage[v1.ResourceCPU]
+		memoryUsage := container.Usage[v1.ResourceMemory]
+		reading.containers = append(reading.containers, containerReading{
+			name:        container.Name,
+			cpuMilli:    cpuUsage.MilliValue(),
+			memoryBytes: memoryUsage.Value(),
+		})
+	}
+	return reading, nil
+}
+
+// checkMetricsAPI verifies the API server serves metrics.k8s.io/v1beta1, so
+// a missing or broken metrics-server stops the run up front instead of
+// failing every pod. It asks discovery rather than listing pod metrics,
+// which would fetch every pod's metrics in the cluster and is forbidden to
+// namespace-scoped users.
+func checkMetricsAPI(discoveryClient discovery.DiscoveryInterface) error {
+	if _, err := discoveryClient.ServerResourcesForGroupVersion("metrics.k8s.io/v1beta1"); err != nil {
+		return fmt.Errorf("metrics API (metrics.k8s.io) unavailable, check that metrics-server is installed and ready or use -source kubelet: %w", err)
+	}
+	return nil
+}
+
+// kubeletSource reads the kubelet's /stats/summary through the API server's
+// node proxy. It needs RBAC for nodes/proxy, but works without
+// metrics-server and exposes cumulative CPU counters.
+type kubeletSource struct {
+	clientset kubernetes.Interface
+}
+
+// kubeletSummary is the subset of the kubelet stats/v1alpha1 Summary the
+// tool reads.
+type kubeletSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Network *struct {
+			RxBytes *uint64 `json:"rxBytes"`
+			TxBytes *uint64 `json:"txBytes"`
+		} `json:"network"`
+		EphemeralStorage *struct {
+			UsedBytes *uint64 `json:"usedBytes"`
+		} `json:"ephemeral-storage"`
+		Containers []struct {
+			Name string `json:"name"`
+			CPU  *struct {
+				Time                 metav1.Time `json:"time"`
+				UsageNanoCores       *uint64     `json:"usageNanoCores"`
+				UsageCoreNanoSeconds *uint64     `json:"usageCoreNanoSeconds"`
+			} `json:"cpu"`
+			Memory *struct {
+				WorkingSetBytes *uint64 `json:"workingSetBytes"`
+			} `json:"memory"`
+		} `json:"containers"`
+	} `json:"pods"`
+}
+
+func (s *kubeletSource) name() string {
+	return sourceKubelet
+}
+
+// summary fetches the stats summary of a node.
+func (s *kubeletSource) summary(ctx context.Context, node string) (*kubeletSummary, error) {
+	data, err := s.clientset.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", node, "proxy", "stats", "summary").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting kubelet summary for node %s: %w", node, err)
+	}
+	summary := &kubeletSummary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		return nil, fmt.Errorf("decoding kubelet summary for node %s: %w", node, err)
+	}
+	return summary, nil
+}
+
+func (s *kubeletSource) read(ctx context.Context, pod *v1.Pod) (*usageReading, error) {
+	if pod.Spec.NodeName == "" {
+		return nil, fmt.Errorf("pod %s/%s is not scheduled to a node", pod.Namespace, pod.Name)
+	}
+	summary, err := s.summary(ctx, pod.Spec.NodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, podStats := range summary.Pods {
+		if podStats.PodRef.Namespace != pod.Namespace || podStats.PodRef.Name != pod.Name {
+			continue
+		}
+		reading := &usageReading{pod: &podReading{}}
+		if podStats.Network != nil && podStats.Network.RxBytes != nil && podStats.Network.TxBytes != nil {
+			reading.pod.networkRxBytes = *podStats.Network.RxBytes
+			reading.pod.networkTxBytes = *podStats.Network.TxBytes
+		}
+		if podStats.EphemeralStorage != nil && podStats.EphemeralStorage.UsedBytes != nil {
+			reading.pod.ephemeralStorageBytes = *podStats.EphemeralStorage.UsedBytes
+		}
+		for _, container := range podStats.Containers {
+			c := containerReading{name: container.Name}
+			if container.CPU != nil {
+				if container.CPU.Time.After(reading.timestamp) {
+					reading.timestamp = container.CPU.Time.Time
+				}
+				if container.CPU.UsageNanoCores != nil {
+					c.cpuMilli = int64(*container.CPU.UsageNanoCores / 1e6)
+				}
+				if container.CPU.UsageCoreNanoSeconds != nil {
+					c.cpuCumulativeNanos = *container.CPU.UsageCoreNanoSeconds
+				}
+			}
+			if container.Memory != nil && container.Memory.WorkingSetBytes != nil {
+				c.memoryBytes = int64(*container.Memory.WorkingSetBytes)
+			}
+			reading.containers = append(reading.containers, c)
+		}
+		return reading, nil
+	}
+	return nil, fmt.Errorf("pod %s/%s not found in kubelet summary of node %s", pod.Namespace, pod.Name, pod.Spec.NodeName)
+}
+
+// prometheusSource queries cAdvisor metrics from a Prometheus server.
+type prometheusSource struct {
+	baseURL string
+	client  *http.Client
+
+	// scraped is the timestamp of each pod's last reading, to tell a new
+	// scrape from one already read, since Prometheus scrapes far less often
+	// than the pods are sampled.
+	mu      sync.Mutex
+	scraped map[types.UID]time.Time
+}
+
+func newPrometheusSource(baseURL string) *prometheusSource {
+	return &prometheusSource{baseURL: baseURL, client: &http.Client{Timeout: 30 * time.Second}, scraped: make(map[types.UID]time.Time)}
+}
+
+// prometheusRateWindow is the range CPU rates are computed over.
+const prometheusRateWindow = 5 * time.Minute
+
+func (s *prometheusSource) name() string {
+	return sourcePrometheus
+}
+
+func (s *prometheusSource) read(ctx context.Context, pod *v1.Pod) (*usageReading, error) {
+	selector := prometheusPodSelector(pod)
+	cpu, err := s.query(ctx, fmt.Sprintf("sum by (container) (rate(container_cpu_usage_seconds_total{%s}[%s]))", selector, prometheusRateWindow), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	memory, err := s.query(ctx, fmt.Sprintf("sum by (container) (container_memory_working_set_bytes{%s})", selector), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	// The reading is as of the latest scrape of the pod, not of the query
+	scrapes, err := s.query(ctx, fmt.Sprintf("max(timestamp(container_memory_working_set_bytes{%s}))", selector), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	at := time.Now()
+	if seconds, ok := scrapes[""]; ok {
+		at = time.UnixMilli(int64(math.Round(seconds * 1000)))
+	}
+	reading, err := prometheusReading(pod, cpu, memory, at)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reading.repeat = s.scraped[pod.UID].Equal(at)
+	s.scraped[pod.UID] = at
+	return reading, nil
+}
+
+// readLifetime reads the peak CPU rate and memory of each container of a
+// finished pod over its lifetime, evaluated when it finished, for
+// -include-terminated: its series have gone stale, so an instant read
+// finds nothing.
+func (s *prometheusSource) readLifetime(ctx context.Context, pod *v1.Pod) (*usageReading, error) {
+	started, finished := podLifetime(pod)
+	if started.IsZero() {
+		return nil, fmt.Errorf("pod %s/%s never started", pod.Namespace, pod.Name)
+	}
+	lifetime := finished.Sub(started)
+	if lifetime < prometheusRateWindow {
+		lifetime = prometheusRateWindow
+	}
+	lifetimeRange := fmt.Sprintf("%ds", int64(lifetime.Seconds()))
+	selector := prometheusPodSelector(pod)
+	cpu, err := s.query(ctx, fmt.Sprintf("max by (container) (max_over_time(rate(container_cpu_usage_seconds_total{%s}[%s])[%s:]))", selector, prometheusRateWindow, lifetimeRange), finished)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := s.query(ctx, fmt.Sprintf("max by (container) (max_over_time(container_memory_working_set_bytes{%s}[%s]))", selector, lifetimeRange), finished)
+	if err != nil {
+		return nil, err
+	}
+	return prometheusReading(pod, cpu, memory, finished)
+}
+
+// prometheusPodSelector matches the cAdvisor series of the pod's
+// containers, leaving out the pod-level and pause container ones.
+func prometheusPodSelector(pod *v1.Pod) string {
+	return fmt.Sprintf(`namespace=%q,pod=%q,container!="",container!="POD"`, pod.Namespace, pod.Name)
+}
+
+// prometheusReading builds the reading of the pod's containers from the
+// per-container CPU cores and memory bytes of a query.
+func prometheusReading(pod *v1.Pod, cpu, memory map[string]float64, at time.Time) (*usageReading, error) {
+	if len(cpu) == 0 && len(memory) == 0 {
+		return nil, fmt.Errorf("no prometheus series for pod %s/%s", pod.Namespace, pod.Name)
+	}
+
+	reading := &usageReading{timestamp: at, window: prometheusRateWindow}
+	for _, container := range pod.Spec.Containers {
+		cores, hasCPU := cpu[container.Name]
+		bytes, hasMemory := memory[container.Name]
+		if !hasCPU && !hasMemory {
+			continue
+		}
+		reading.containers = append(reading.containers, containerReading{
+			name:        container.Name,
+			cpuMilli:    int64(cores * 1000),
+			memoryBytes: int64(bytes),
+		})
+	}
+	return reading, nil
+}
+
+// lifetimeSource reads finished pods from Prometheus with readLifetime.
+type lifetimeSource struct {
+	prometheus *prometheusSource
+}
+
+func (s lifetimeSource) name() string {
+	return sourcePrometheus
+}
+
+func (s lifetimeSource) read(ctx context.Context, pod *v1.Pod) (*usageReading, error) {
+	return s.prometheus.readLifetime(ctx, pod)
+}
+
+// query runs an instant query, at the given time or now if it is zero, and
+// returns the value of each series keyed by its container label.
+func (s *prometheusSource) query(ctx context.Context, query string, at time.Time) (map[string]float64, error) {
+	params := url.Values{"query": {query}}
+	if !at.IsZero() {
+		params.Set("time", strconv.FormatInt(at.Unix(), 10))
+	}
+	endpoint := s.baseURL + "/api/v1/query?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building prometheus query: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+				Value  [2]interface{}    `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding prometheus response: %w", err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s", body.Error)
+	}
+
+	values := make(map[string]float64, len(body.Data.Result))
+	for _, series := range body.Data.Result {
+		text, ok := series.Value[1].(string)
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			continue
+		}
+		values[series.Metric["container"]] = value
+	}
+	return values, nil
+}
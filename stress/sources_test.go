@@ -0,0 +1,68 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPrometheusReadingTimestamp(t *testing.T) {
+	var mu sync.Mutex
+	scrapedAt := 1790000000.123
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		query := r.URL.Query().Get("query")
+		value := `{"metric":{"container":"app"},"value":[1790000060,"%s"]}`
+		switch {
+		case strings.HasPrefix(query, "max(timestamp("):
+			value = fmt.Sprintf(`{"metric":{},"value":[1790000060,"%.3f"]}`, scrapedAt)
+		case strings.Contains(query, "cpu"):
+			value = fmt.Sprintf(value, "0.25")
+		default:
+			value = fmt.Sprintf(value, "1048576")
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, value)
+	}))
+	defer server.Close()
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-1", UID: "uid-1"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+	}
+	source := newPrometheusSource(server.URL)
+	read := func() *usageReading {
+		t.Helper()
+		reading, err := source.read(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return reading
+	}
+
+	first := read()
+	if want := time.UnixMilli(1790000000123); !first.timestamp.Equal(want) {
+		t.Errorf("timestamp = %s, want the scrape's %s", first.timestamp, want)
+	}
+	if first.repeat || len(first.containers) != 1 || first.containers[0].cpuMilli != 250 {
+		t.Errorf("first reading = %+v, want a new reading of 250m", first)
+	}
+	if again := read(); !again.repeat {
+		t.Errorf("reading of the same scrape isn't marked as a repeat")
+	}
+
+	mu.Lock()
+	scrapedAt += 15
+	mu.Unlock()
+	if next := read(); next.repeat {
+		t.Errorf("reading of a new scrape is marked as a repeat")
+	}
+}
@@ -0,0 +1,83 @@
+package stress
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the pure-Go "sqlite" driver
+)
+
+// sqliteSchema creates the results table that every run appends to.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS results (
+	run_id TEXT NOT NULL,
+	run_at TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	pod TEXT NOT NULL,
+	owner TEXT NOT NULL,
+	owner_kind TEXT NOT NULL,
+	image TEXT NOT NULL,
+	image_id TEXT NOT NULL,
+	qos_class TEXT NOT NULL,
+	source TEXT NOT NULL,
+	samples INTEGER NOT NULL,
+	cpu_milli INTEGER NOT NULL,
+	memory_bytes INTEGER NOT NULL,
+	peak_memory_limit_pct REAL,
+	custom_metric TEXT NOT NULL
+)`
+
+// sqliteResultWriter inserts each result as a row of the results table,
+// stamped with the run's ID and start time so runs can be queried together.
+// Figures are stored unformatted: millicores, bytes and a percentage that is
+// NULL for containers without a memory limit.
+type sqliteResultWriter struct {
+	db     *sql.DB
+	insert *sql.Stmt
+	runID  string
+	runAt  string
+}
+
+// newSQLiteResultWriter opens or creates the database at path and its
+// schema.
+func newSQLiteResultWriter(path, runID string, runAt time.Time) (ResultWriter, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening sqlite database: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating sqlite schema: %w", err)
+	}
+	insert, err := db.Prepare(`INSERT INTO results (run_id, run_at, namespace, pod, owner, owner_kind, image, image_id,
+		qos_class, source, samples, cpu_milli, memory_bytes, peak_memory_limit_pct, custom_metric)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("preparing sqlite insert: %w", err)
+	}
+	return &sqliteResultWriter{db: db, insert: insert, runID: runID, runAt: runAt.UTC().Format(time.RFC3339)}, nil
+}
+
+func (s *sqliteResultWriter) WriteHeader() error {
+	return nil
+}
+
+func (s *sqliteResultWriter) WriteRow(r *PodResult) error {
+	var limitPercent sql.NullFloat64
+	limitPercent.Float64, limitPercent.Valid = r.PeakMemoryLimitPercent()
+	_, err := s.insert.Exec(s.runID, s.runAt, r.Namespace, r.Pod, r.Owner, r.OwnerKind, r.Image, r.ImageID,
+		r.QOSClass, r.Source, r.Samples, r.AvgCPUMilli, r.AvgMemoryBytes, limitPercent, r.CustomMetric)
+	if err != nil {
+		return fmt.Errorf("inserting sqlite row: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteResultWriter) Close() error {
+	err := s.insert.Close()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+package stress
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// overflowWarning logs the first clamped sum or product of a run.
+var overflowWarning sync.Once
+
+func warnOverflow() {
+	overflowWarning.Do(func() {
+		klog.Warning("Usage totals overflowed int64 and were clamped; aggregated figures are capped and inaccurate")
+	})
+}
+
+// addClamped returns a+b, clamped to the int64 range instead of wrapping
+// around, with a warning.
+func addClamped(a, b int64) int64 {
+	sum := a + b
+	if b > 0 && sum < a {
+		warnOverflow()
+		return math.MaxInt64
+	}
+	if b < 0 && sum > a {
+		warnOverflow()
+		return math.MinInt64
+	}
+	return sum
+}
+
+// clampedInt64 converts v to int64, clamping values beyond its range.
+func clampedInt64(v uint64) int64 {
+	if v > math.MaxInt64 {
+		warnOverflow()
+		return math.MaxInt64
+	}
+	return int64(v)
+}
+
+// mulClamped returns a*b, clamped like addClamped.
+func mulClamped(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	product := a * b
+	if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		warnOverflow()
+		if (a > 0) == (b > 0) {
+			return math.MaxInt64
+		}
+		return math.MinInt64
+	}
+	return product
+}
+
+// freshnessWeight returns how much a metrics reading taken at sampledAt
+// should count towards a -weighted average. With age = sampledAt - timestamp,
+// the weight is
+//
+//	w = window / (window + age)
+//
+// so a reading that was just produced has weight 1, one that is a full
+// metrics window old has weight 1/2, and stale readings decay towards 0.
+// Readings without a window use a 15s window, metrics-server's default
+// resolution.
+func freshnessWeight(timestamp, sampledAt time.Time, window time.Duration) float64 {
+	if window <= 0 {
+		window = 15 * time.Second
+	}
+	age := sampledAt.Sub(timestamp)
+	if age < 0 {
+		age = 0
+	}
+	return window.Seconds() / (window.Seconds() + age.Seconds())
+}
+
+// weightedMean accumulates a weighted average of CPU and memory samples.
+type weightedMean struct {
+	cpuMilli    float64
+	memoryBytes float64
+	weight      float64
+}
+
+func (m *weightedMean) add(weight float64, cpuMilli, memoryBytes int64) {
+	m.cpuMilli += weight * float64(cpuMilli)
+	m.memoryBytes += weight * float64(memoryBytes)
+	m.weight += weight
+}
+
+// mean returns the weighted averages, and false if nothing was added.
+func (m *weightedMean) mean() (int64, int64, bool) {
+	if m.weight <= 0 {
+		return 0, 0, false
+	}
+	return int64(m.cpuMilli / m.weight), int64(m.memoryBytes / m.weight), true
+}
+
+// Sample strategies accepted by -sample-strategy.
+const (
+	strategyMean   = "mean"
+	strategyMedian = "median"
+	strategyMax    = "max"
+	strategyLast   = "last"
+)
+
+// checkSampleStrategy returns an error for an unknown -sample-strategy.
+func checkSampleStrategy(strategy string) error {
+	switch strategy {
+	case strategyMean, strategyMedian, strategyMax, strategyLast:
+		return nil
+	default:
+		return fmt.Errorf("unknown sample strategy %q, want %s, %s, %s or %s", strategy, strategyMean, strategyMedian, strategyMax, strategyLast)
+	}
+}
+
+// collapse reduces samples, in the order taken, to one value. The median of
+// an even number of samples is the mean of the middle two. It returns 0 for
+// no samples.
+func collapse(strategy string, samples []int64) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	switch strategy {
+	case strategyMedian:
+		sorted := append([]int64(nil), samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			// Halve the gap rather than the sum, which can overflow
+			return sorted[mid-1] + (sorted[mid]-sorted[mid-1])/2
+		}
+		return sorted[mid]
+	case strategyMax:
+		max := samples[0]
+		for _, sample := range samples[1:] {
+			if sample > max {
+				max = sample
+			}
+		}
+		return max
+	case strategyLast:
+		return samples[len(samples)-1]
+	default:
+		var total int64
+		for _, sample := range samples {
+			total = addClamped(total, sample)
+		}
+		return total / int64(len(samples))
+	}
+}
@@ -0,0 +1,139 @@
+package stress
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const tebibyte = int64(1) << 40
+
+func TestAddClamped(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int64
+		want int64
+	}{
+		{"small", 2, 3, 5},
+		{"max plus one", math.MaxInt64, 1, math.MaxInt64},
+		{"min minus one", math.MinInt64, -1, math.MinInt64},
+		{"max plus max", math.MaxInt64, math.MaxInt64, math.MaxInt64},
+		{"max plus min", math.MaxInt64, math.MinInt64, -1},
+		{"min plus zero", math.MinInt64, 0, math.MinInt64},
+	}
+	for _, test := range tests {
+		if got := addClamped(test.a, test.b); got != test.want {
+			t.Errorf("%s: addClamped(%d, %d) = %d, want %d", test.name, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestMulClamped(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int64
+		want int64
+	}{
+		{"small", 6, 7, 42},
+		{"zero", math.MaxInt64, 0, 0},
+		{"max times two", math.MaxInt64, 2, math.MaxInt64},
+		{"max times minus two", math.MaxInt64, -2, math.MinInt64},
+		{"min times minus one", math.MinInt64, -1, math.MaxInt64},
+		{"minus one times min", -1, math.MinInt64, math.MaxInt64},
+		{"min times one", math.MinInt64, 1, math.MinInt64},
+		{"Ti samples", 7 * tebibyte, 1000, 7000 * tebibyte},
+	}
+	for _, test := range tests {
+		if got := mulClamped(test.a, test.b); got != test.want {
+			t.Errorf("%s: mulClamped(%d, %d) = %d, want %d", test.name, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestClampedInt64(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want int64
+	}{
+		{0, 0},
+		{math.MaxInt64, math.MaxInt64},
+		{math.MaxInt64 + 1, math.MaxInt64},
+		{math.MaxUint64, math.MaxInt64},
+	}
+	for _, test := range tests {
+		if got := clampedInt64(test.v); got != test.want {
+			t.Errorf("clampedInt64(%d) = %d, want %d", test.v, got, test.want)
+		}
+	}
+}
+
+// fakeSource returns one reading of container "app" per call, with the
+// next of memory.
+type fakeSource struct {
+	memory []int64
+}
+
+func (f *fakeSource) name() string { return "fake" }
+
+func (f *fakeSource) read(context.Context, *v1.Pod) (*usageReading, error) {
+	memoryBytes := f.memory[0]
+	f.memory = f.memory[1:]
+	return &usageReading{containers: []containerReading{{name: "app", cpuMilli: 100, memoryBytes: memoryBytes}}}, nil
+}
+
+func TestPeakMemoryTebibytes(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []int64
+		wantPeak  int64
+		wantTotal int64
+	}{
+		{"Ti samples", []int64{4 * tebibyte, 7 * tebibyte, 6 * tebibyte}, 7 * tebibyte, 17 * tebibyte},
+		{"peak first", []int64{5000 * tebibyte, tebibyte}, 5000 * tebibyte, 5001 * tebibyte},
+		{"total overflows", []int64{math.MaxInt64 - tebibyte, 2 * tebibyte, tebibyte}, math.MaxInt64 - tebibyte, math.MaxInt64},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-1"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+		Status:     v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{Name: "app"}}},
+	}
+	for _, test := range tests {
+		source := &fakeSource{memory: test.samples}
+		s := &sampler{stats: &runStats{}}
+		state := &podSampling{pod: pod, source: source}
+		var usage podUsage
+		for range test.samples {
+			if !s.sampleOnce(context.Background(), state, &usage) {
+				t.Fatalf("%s: sampleOnce stopped early", test.name)
+			}
+		}
+		totals := usage.container("app")
+		if totals.peakMemory != test.wantPeak {
+			t.Errorf("%s: peak memory = %d, want %d", test.name, totals.peakMemory, test.wantPeak)
+		}
+		if totals.memoryTotal != test.wantTotal {
+			t.Errorf("%s: memory total = %d, want %d", test.name, totals.memoryTotal, test.wantTotal)
+		}
+	}
+}
+
+func TestCollapseMedian(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int64
+		want    int64
+	}{
+		{"odd", []int64{3, 1, 2}, 2},
+		{"even", []int64{4, 1, 2, 3}, 2},
+		{"even near max", []int64{math.MaxInt64, math.MaxInt64 - 2}, math.MaxInt64 - 1},
+		{"Ti samples", []int64{5000 * tebibyte, 7000 * tebibyte}, 6000 * tebibyte},
+	}
+	for _, test := range tests {
+		if got := collapse(strategyMedian, test.samples); got != test.want {
+			t.Errorf("%s: median of %v = %d, want %d", test.name, test.samples, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog"
+)
+
+// runStats counts data-quality figures across the whole run. Workers update
+// it concurrently, so every counter is atomic.
+type runStats struct {
+	samplesAttempted  atomic.Int64
+	samplesSuccessful atomic.Int64
+	apiErrors         atomic.Int64
+	disappeared       atomic.Int64
+	timedOut          atomic.Int64
+	tooYoung          atomic.Int64
+	zeroUsage         atomic.Int64
+
+	failuresMu sync.Mutex
+	failures   map[string]int64
+}
+
+// Buckets of the per-pod failures counted in the summary.
+const (
+	failureNotFound           = "not-found"
+	failureForbidden          = "forbidden"
+	failureTimeout            = "timeout"
+	failureMetricsUnavailable = "metrics-unavailable"
+	failureExecFailed         = "exec-failed"
+	failureOther              = "other"
+)
+
+// failureBuckets lists the buckets in the order they are logged.
+var failureBuckets = []string{failureNotFound, failureForbidden, failureTimeout, failureMetricsUnavailable, failureExecFailed, failureOther}
+
+// classifyFailure buckets the error that failed a pod. RBAC and timeouts
+// are told apart first whatever the call; otherwise a failed metrics read,
+// NotFound included, is metrics-unavailable and a failed exec exec-failed,
+// both given as fallback, and any other NotFound is not-found.
+func classifyFailure(err error, fallback string) string {
+	switch {
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return failureForbidden
+	case apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) || errors.Is(err, context.DeadlineExceeded):
+		return failureTimeout
+	case fallback == failureMetricsUnavailable || fallback == failureExecFailed:
+		return fallback
+	case apierrors.IsNotFound(err):
+		return failureNotFound
+	default:
+		return failureOther
+	}
+}
+
+// fail counts a per-pod failure in bucket.
+func (s *runStats) fail(bucket string) {
+	s.failuresMu.Lock()
+	defer s.failuresMu.Unlock()
+	if s.failures == nil {
+		s.failures = make(map[string]int64)
+	}
+	s.failures[bucket]++
+}
+
+// failureCounts returns a copy of the counts per bucket.
+func (s *runStats) failureCounts() map[string]int64 {
+	s.failuresMu.Lock()
+	defer s.failuresMu.Unlock()
+	counts := make(map[string]int64, len(s.failures))
+	for bucket, count := range s.failures {
+		counts[bucket] = count
+	}
+	return counts
+}
+
+// log prints the run totals as part of the final summary.
+func (s *runStats) log() {
+	attempted := s.samplesAttempted.Load()
+	successful := s.samplesSuccessful.Load()
+	var ratio float64
+	if attempted > 0 {
+		ratio = 100 * float64(successful) / float64(attempted)
+	}
+	klog.Infof("Samples attempted: %d, successful: %d (%.1f%%), API errors: %d, pods disappeared: %d, timed out: %d", attempted, successful, ratio, s.apiErrors.Load(), s.disappeared.Load(), s.timedOut.Load())
+	if tooYoung := s.tooYoung.Load(); tooYoung > 0 {
+		klog.Infof("Pods younger than -min-age skipped: %d", tooYoung)
+	}
+	if zeroUsage := s.zeroUsage.Load(); zeroUsage > 0 {
+		klog.Infof("Rows of zero usage omitted without -report-zero: %d", zeroUsage)
+	}
+	counts := s.failureCounts()
+	var buckets []string
+	for _, bucket := range failureBuckets {
+		if counts[bucket] > 0 {
+			buckets = append(buckets, fmt.Sprintf("%s %d", bucket, counts[bucket]))
+		}
+	}
+	if len(buckets) > 0 {
+		klog.Infof("Pod failures: %s", strings.Join(buckets, ", "))
+	}
+}
@@ -0,0 +1,48 @@
+package stress
+
+import (
+	"context"
+	"time"
+)
+
+// callTimeouts bounds each Kubernetes API call by its kind: Gets of single
+// objects and pod metrics, Lists, and the stress execs, whose stream lasts
+// as long as the command. Zero leaves a kind of call unbounded.
+type callTimeouts struct {
+	get  time.Duration
+	list time.Duration
+	exec time.Duration
+}
+
+// newCallTimeouts returns the -get-timeout, -list-timeout and
+// -exec-timeout bounds, each falling back to the -timeout default when
+// unset.
+func newCallTimeouts(defaultTimeout, get, list, exec time.Duration) callTimeouts {
+	fallback := func(timeout time.Duration) time.Duration {
+		if timeout == 0 {
+			return defaultTimeout
+		}
+		return timeout
+	}
+	return callTimeouts{get: fallback(get), list: fallback(list), exec: fallback(exec)}
+}
+
+func (t callTimeouts) forGet(ctx context.Context) (context.Context, context.CancelFunc) {
+	return boundContext(ctx, t.get)
+}
+
+func (t callTimeouts) forList(ctx context.Context) (context.Context, context.CancelFunc) {
+	return boundContext(ctx, t.list)
+}
+
+func (t callTimeouts) forExec(ctx context.Context) (context.Context, context.CancelFunc) {
+	return boundContext(ctx, t.exec)
+}
+
+// boundContext returns ctx with timeout, or only cancellable if it is zero.
+func boundContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,76 @@
+package stress
+
+import "time"
+
+// Values of the -trend-duration trend column.
+const (
+	trendIncreasing = "increasing"
+	trendDecreasing = "decreasing"
+	trendFlat       = "flat"
+)
+
+// trendFlatPercent is how much the fitted memory may change over the
+// sampled span, as a percentage of its mean, and still count as flat.
+const trendFlatPercent = 5
+
+// minTrendPoints is the fewest readings a trend is fitted to.
+const minTrendPoints = 3
+
+// trendPoint is a pod's total memory in one reading.
+type trendPoint struct {
+	at          time.Time
+	memoryBytes int64
+}
+
+// memoryTrend collects memory readings over time to fit a line through.
+// For deployments the pods' readings are pooled, each pod's in turn.
+type memoryTrend struct {
+	points []trendPoint
+}
+
+func (t *memoryTrend) add(at time.Time, memoryBytes int64) {
+	t.points = append(t.points, trendPoint{at: at, memoryBytes: memoryBytes})
+}
+
+// classify fits memory against time by least squares and reports whether
+// it rose or fell by more than trendFlatPercent of its mean over the
+// sampled span. It is false with too few readings or no time between them.
+func (t *memoryTrend) classify() (string, bool) {
+	if len(t.points) < minTrendPoints {
+		return "", false
+	}
+	first := t.points[0].at
+	var meanX, meanY float64
+	for _, point := range t.points {
+		meanX += point.at.Sub(first).Seconds()
+		meanY += float64(point.memoryBytes)
+	}
+	n := float64(len(t.points))
+	meanX /= n
+	meanY /= n
+	var covariance, variance, span float64
+	for _, point := range t.points {
+		x := point.at.Sub(first).Seconds()
+		dx := x - meanX
+		covariance += dx * (float64(point.memoryBytes) - meanY)
+		variance += dx * dx
+		if x > span {
+			span = x
+		}
+	}
+	if variance == 0 {
+		return "", false
+	}
+	if meanY == 0 {
+		return trendFlat, true
+	}
+	change := covariance / variance * span / meanY * 100
+	switch {
+	case change > trendFlatPercent:
+		return trendIncreasing, true
+	case change < -trendFlatPercent:
+		return trendDecreasing, true
+	default:
+		return trendFlat, true
+	}
+}
@@ -0,0 +1,95 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// podWaitInterval is the pause between -wait-for-pods polls.
+const podWaitInterval = 2 * time.Second
+
+// waitForPods polls until every target pod exists and is Running, for
+// -wait-for-pods, listing each namespace with pending pods once per poll.
+// Once timeout elapses it logs and returns the pods still missing or not
+// Running; they are measured anyway and fail as usual. A listing error
+// other than forbidden is logged and retried at the next poll.
+func waitForPods(ctx context.Context, clientset kubernetes.Interface, targets []Target, timeout time.Duration, timeouts callTimeouts) ([]Target, error) {
+	deadline := time.Now().Add(timeout)
+	pending := targets
+	phases := make(map[Target]v1.PodPhase)
+	klog.Infof("Waiting up to %s for %d pods to be Running", timeout, len(targets))
+	for {
+		var err error
+		pending, err = pendingPods(ctx, clientset, pending, phases, timeouts)
+		if apierrors.IsForbidden(err) {
+			return nil, err
+		}
+		if err != nil {
+			klog.Warningf("Error checking for pods, retrying: %v", err)
+		}
+		if err == nil && len(pending) == 0 {
+			klog.Infof("All %d pods are Running", len(targets))
+			return nil, nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		if wait > podWaitInterval {
+			wait = podWaitInterval
+		}
+		klog.V(1).Infof("Waiting for %d of %d pods to be Running", len(pending), len(targets))
+		if !sleepContext(ctx, wait) {
+			return pending, ctx.Err()
+		}
+	}
+
+	for _, target := range pending {
+		if phase, found := phases[target]; found {
+			klog.Errorf("Pod %s/%s is still %s after -wait-for-pods %s", target.Namespace, target.Name, phase, timeout)
+		} else {
+			klog.Errorf("Pod %s/%s never appeared within -wait-for-pods %s", target.Namespace, target.Name, timeout)
+		}
+	}
+	return pending, nil
+}
+
+// pendingPods returns the targets that don't exist or aren't Running yet,
+// recording the phase of those that exist in phases.
+func pendingPods(ctx context.Context, clientset kubernetes.Interface, targets []Target, phases map[Target]v1.PodPhase, timeouts callTimeouts) ([]Target, error) {
+	listed := make(map[string]map[string]v1.PodPhase)
+	var pending []Target
+	for _, target := range targets {
+		pods, ok := listed[target.Namespace]
+		if !ok {
+			listCtx, cancel := timeouts.forList(ctx)
+			list, err := clientset.CoreV1().Pods(target.Namespace).List(listCtx, metav1.ListOptions{})
+			cancel()
+			if err != nil {
+				return targets, fmt.Errorf("listing pods in namespace %s: %w", target.Namespace, err)
+			}
+			pods = make(map[string]v1.PodPhase, len(list.Items))
+			for _, pod := range list.Items {
+				pods[pod.Name] = pod.Status.Phase
+			}
+			listed[target.Namespace] = pods
+		}
+		phase, found := pods[target.Name]
+		if found {
+			phases[target] = phase
+		} else {
+			delete(phases, target)
+		}
+		if phase != v1.PodRunning {
+			pending = append(pending, target)
+		}
+	}
+	return pending, nil
+}
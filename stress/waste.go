@@ -0,0 +1,84 @@
+package stress
+
+import (
+	"sort"
+
+	"k8s.io/klog"
+)
+
+// Waste is how much of a row's resource requests went unused: the requests
+// of its containers minus their usage, counting only containers with a
+// request. Usage above the request makes it negative.
+type Waste struct {
+	Namespace   string
+	Name        string
+	CPUMilli    int64
+	MemoryBytes int64
+}
+
+// wasteOf returns the waste of a result, and false if none of its
+// containers has a CPU or memory request.
+func wasteOf(r *PodResult) (Waste, bool) {
+	waste := Waste{Namespace: r.Namespace, Name: r.Pod}
+	if waste.Name == "" {
+		waste.Name = r.Owner
+	}
+	var requested bool
+	for _, container := range r.Containers {
+		if container.CPURequestMilli > 0 {
+			waste.CPUMilli += container.CPURequestMilli - container.AvgCPUMilli
+			requested = true
+		}
+		if container.MemoryRequestBytes > 0 {
+			waste.MemoryBytes += container.MemoryRequestBytes - container.AvgMemoryBytes
+			requested = true
+		}
+	}
+	return waste, requested
+}
+
+// wasteReport collects the waste of every row for -compare-requests.
+type wasteReport struct {
+	top     int
+	entries []Waste
+}
+
+func (w *wasteReport) add(r *PodResult) {
+	if waste, ok := wasteOf(r); ok {
+		w.entries = append(w.entries, waste)
+	}
+}
+
+// worst returns the top rows by wasted memory, then CPU, ties in
+// namespace and name order.
+func (w *wasteReport) worst() []Waste {
+	sorted := append([]Waste(nil), w.entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].MemoryBytes != sorted[j].MemoryBytes {
+			return sorted[i].MemoryBytes > sorted[j].MemoryBytes
+		}
+		if sorted[i].CPUMilli != sorted[j].CPUMilli {
+			return sorted[i].CPUMilli > sorted[j].CPUMilli
+		}
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	if w.top > 0 && len(sorted) > w.top {
+		sorted = sorted[:w.top]
+	}
+	return sorted
+}
+
+// logWaste prints the worst rows as part of the final summary.
+func logWaste(worst []Waste, quantities quantityFormat) {
+	if len(worst) == 0 {
+		klog.Info("No measured rows have resource requests to compare")
+		return
+	}
+	klog.Infof("Top %d rows by unused requests (memory, then CPU):", len(worst))
+	for i, waste := range worst {
+		klog.Infof("  %d. %s/%s: memory %s, cpu %s", i+1, waste.Namespace, waste.Name, quantities.memory(waste.MemoryBytes), quantities.cpu(waste.CPUMilli))
+	}
+}
@@ -0,0 +1,81 @@
+package stress
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// activeWindow is a daily time-of-day range, e.g. 09:00-17:00, in a given
+// location. A window whose end is before its start spans midnight.
+type activeWindow struct {
+	start, end time.Duration // offsets from midnight
+	location   *time.Location
+}
+
+// parseActiveWindow parses an -active-window range of HH:MM times, in the
+// named IANA time zone or the local one if tz is empty.
+func parseActiveWindow(spec, tz string) (*activeWindow, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid active window %q, want HH:MM-HH:MM", spec)
+	}
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active window %q: %w", spec, err)
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active window %q: %w", spec, err)
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid active window %q: start and end are equal", spec)
+	}
+	location := time.Local
+	if tz != "" {
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("loading time zone: %w", err)
+		}
+	}
+	return &activeWindow{start: start, end: end, location: location}, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// at returns the time of day offset on t's day, plus days, in the window's
+// location. It builds the wall-clock time with time.Date rather than adding
+// offset to midnight, which is off by the change on a DST transition day.
+func (w *activeWindow) at(t time.Time, days int, offset time.Duration) time.Time {
+	local := t.In(w.location)
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+	return time.Date(local.Year(), local.Month(), local.Day()+days, hour, minute, 0, 0, w.location)
+}
+
+// contains reports whether t falls inside the window.
+func (w *activeWindow) contains(t time.Time) bool {
+	start, end := w.at(t, 0, w.start), w.at(t, 0, w.end)
+	if w.start < w.end {
+		return !t.Before(start) && t.Before(end)
+	}
+	return !t.Before(start) || t.Before(end)
+}
+
+// untilOpen returns how long after t the window next opens, zero if it is
+// already open.
+func (w *activeWindow) untilOpen(t time.Time) time.Duration {
+	if w.contains(t) {
+		return 0
+	}
+	open := w.at(t, 0, w.start)
+	if !open.After(t) {
+		open = w.at(t, 1, w.start)
+	}
+	return open.Sub(t)
+}
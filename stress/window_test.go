@@ -0,0 +1,38 @@
+package stress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActiveWindowDST(t *testing.T) {
+	// America/New_York falls back from 02:00 EDT to 01:00 EST on 2026-11-01,
+	// a 25 hour day
+	w, err := parseActiveWindow("09:00-17:00", "America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2026, time.November, 1, hour, minute, 0, 0, w.location)
+	}
+	tests := []struct {
+		t         time.Time
+		contains  bool
+		untilOpen time.Duration
+	}{
+		{at(8, 0), false, time.Hour},
+		{at(8, 30), false, 30 * time.Minute},
+		{at(9, 0), true, 0},
+		{at(16, 59), true, 0},
+		{at(17, 0), false, 16 * time.Hour},
+		{at(0, 30), false, 9*time.Hour + 30*time.Minute},
+	}
+	for _, test := range tests {
+		if got := w.contains(test.t); got != test.contains {
+			t.Errorf("contains(%s) = %v, want %v", test.t, got, test.contains)
+		}
+		if got := w.untilOpen(test.t); got != test.untilOpen {
+			t.Errorf("untilOpen(%s) = %s, want %s", test.t, got, test.untilOpen)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package stress
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// ResultWriter serializes results in one output format. WriteHeader is
+// called once before any rows, and Close finishes the output and closes the
+// underlying writer.
+type ResultWriter interface {
+	WriteHeader() error
+	WriteRow(*PodResult) error
+	Close() error
+}
+
+// writerOptions holds the format-specific output flags.
+type writerOptions struct {
+	csvCRLF        bool
+	csvAlwaysQuote bool
+	csvHeader      bool // start CSV with the column names, as split files do
+}
+
+// resultWriters maps each -format value to its writer, which writes the
+// columns of layout.
+var resultWriters = map[string]func(io.WriteCloser, resultLayout, writerOptions) ResultWriter{
+	formatCSV:      newCSVResultWriter,
+	formatJSON:     newJSONResultWriter,
+	formatMarkdown: newMarkdownResultWriter,
+}
+
+// newFormatWriter creates the writer for -format, or for -template when
+// tmpl is set, writing the columns of layout.
+func newFormatWriter(format string, tmpl *template.Template, w io.WriteCloser, layout resultLayout, opts writerOptions) ResultWriter {
+	if tmpl != nil {
+		return newTemplateResultWriter(w, tmpl)
+	}
+	return resultWriters[format](w, layout, opts)
+}
+
+func newTableWriter(opts tableOptions, layout resultLayout, next ResultWriter) ResultWriter {
+	return &tableWriter{opts: opts, layout: layout, next: next}
+}
+
+// tableWriter applies -sort and -limit in front of a format writer. Results
+// are passed straight through unless sorting or limiting needs the full
+// set, in which case they are held until Close.
+type tableWriter struct {
+	opts    tableOptions
+	layout  resultLayout
+	next    ResultWriter
+	pending []*PodResult
+}
+
+func (t *tableWriter) WriteHeader() error {
+	return t.next.WriteHeader()
+}
+
+func (t *tableWriter) WriteRow(result *PodResult) error {
+	if t.opts.buffered() {
+		t.pending = append(t.pending, result)
+		return nil
+	}
+	return t.next.WriteRow(result)
+}
+
+func (t *tableWriter) Close() error {
+	// Keep writing after a failed row so one sink's error doesn't cost the
+	// others the rest of the rows; the first error is returned
+	var err error
+	for _, result := range t.opts.arrange(t.layout, t.pending) {
+		if rowErr := t.next.WriteRow(result); err == nil {
+			err = rowErr
+		}
+	}
+	t.pending = nil
+	if closeErr := t.next.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// discardResultWriter drops every row, for -mode stress.
+type discardResultWriter struct{}
+
+func (discardResultWriter) WriteHeader() error        { return nil }
+func (discardResultWriter) WriteRow(*PodResult) error { return nil }
+func (discardResultWriter) Close() error              { return nil }
+
+// totalsWriter sums the CPU and memory of the rows it passes on and, on
+// Close, writes their -append-summary total row last.
+type totalsWriter struct {
+	next  ResultWriter
+	total PodResult
+}
+
+func newTotalsWriter(next ResultWriter) *totalsWriter {
+	return &totalsWriter{next: next, total: PodResult{total: true}}
+}
+
+func (t *totalsWriter) WriteHeader() error {
+	return t.next.WriteHeader()
+}
+
+func (t *totalsWriter) WriteRow(result *PodResult) error {
+	t.total.AvgCPUMilli = addClamped(t.total.AvgCPUMilli, result.AvgCPUMilli)
+	t.total.AvgMemoryBytes = addClamped(t.total.AvgMemoryBytes, result.AvgMemoryBytes)
+	return t.next.WriteRow(result)
+}
+
+func (t *totalsWriter) Close() error {
+	err := t.next.WriteRow(&t.total)
+	if closeErr := t.next.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// teeResultWriter passes every call on to each of its writers, returning
+// the first error once all of them have been called.
+type teeResultWriter []ResultWriter
+
+func (t teeResultWriter) WriteHeader() error {
+	var err error
+	for _, w := range t {
+		if wErr := w.WriteHeader(); err == nil {
+			err = wErr
+		}
+	}
+	return err
+}
+
+func (t teeResultWriter) WriteRow(result *PodResult) error {
+	var err error
+	for _, w := range t {
+		if wErr := w.WriteRow(result); err == nil {
+			err = wErr
+		}
+	}
+	return err
+}
+
+func (t teeResultWriter) Close() error {
+	var err error
+	for _, w := range t {
+		if wErr := w.Close(); err == nil {
+			err = wErr
+		}
+	}
+	return err
+}
+
+// csvResultWriter writes CSV, headerless unless opts.csvHeader is set,
+// flushing after each row so partial results survive an interrupted run.
+// csv.Writer only quotes fields that need it, so -csv-always-quote rows are
+// formatted by hand.
+type csvResultWriter struct {
+	w           io.WriteCloser
+	layout      resultLayout
+	csv         *csv.Writer
+	alwaysQuote bool
+	header      bool
+}
+
+func newCSVResultWriter(w io.WriteCloser, layout resultLayout, opts writerOptions) ResultWriter {
+	c := &csvResultWriter{w: w, layout: layout, csv: csv.NewWriter(w), alwaysQuote: opts.csvAlwaysQuote, header: opts.csvHeader}
+	c.csv.UseCRLF = opts.csvCRLF
+	return c
+}
+
+func (c *csvResultWriter) WriteHeader() error {
+	if !c.header {
+		return nil
+	}
+	return c.write(c.layout.names())
+}
+
+func (c *csvResultWriter) WriteRow(result *PodResult) error {
+	return c.write(c.layout.row(result))
+}
+
+func (c *csvResultWriter) write(row []string) error {
+	if c.alwaysQuote {
+		return c.writeQuoted(row)
+	}
+	if err := c.csv.Write(row); err != nil {
+		return err
+	}
+
+	// Flush the writer after each pod
+	c.csv.Flush()
+	return c.csv.Error()
+}
+
+// writeQuoted writes a row with every field quoted, doubling embedded
+// quotes as csv.Writer does.
+func (c *csvResultWriter) writeQuoted(row []string) error {
+	var b strings.Builder
+	for i, field := range row {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(`"` + strings.ReplaceAll(field, `"`, `""`) + `"`)
+	}
+	if c.csv.UseCRLF {
+		b.WriteString("\r\n")
+	} else {
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(c.w, b.String())
+	return err
+}
+
+func (c *csvResultWriter) Close() error {
+	c.csv.Flush()
+	err := c.csv.Error()
+	if closeErr := c.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// jsonResultWriter writes a JSON array with one object per row, keyed by
+// column name in header order.
+type jsonResultWriter struct {
+	w      io.WriteCloser
+	layout resultLayout
+	rows   int
+}
+
+func newJSONResultWriter(w io.WriteCloser, layout resultLayout, _ writerOptions) ResultWriter {
+	return &jsonResultWriter{w: w, layout: layout}
+}
+
+func (j *jsonResultWriter) WriteHeader() error {
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonResultWriter) WriteRow(result *PodResult) error {
+	var b bytes.Buffer
+	if j.rows > 0 {
+		b.WriteString(",")
+	}
+	b.WriteString("\n  ")
+	writeJSONObject(&b, j.layout, result)
+	j.rows++
+	_, err := j.w.Write(b.Bytes())
+	return err
+}
+
+// writeJSONObject writes a result as one JSON object keyed by column name,
+// in layout order.
+func writeJSONObject(b *bytes.Buffer, layout resultLayout, result *PodResult) {
+	b.WriteString("{")
+	for i, column := range layout {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		key, _ := json.Marshal(column.name)
+		encoded, _ := json.Marshal(column.value(result))
+		b.Write(key)
+		b.WriteString(": ")
+		b.Write(encoded)
+	}
+	b.WriteString("}")
+}
+
+func (j *jsonResultWriter) Close() error {
+	_, err := io.WriteString(j.w, "\n]\n")
+	if closeErr := j.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// markdownResultWriter writes a GitHub-flavored Markdown table.
+type markdownResultWriter struct {
+	w      io.WriteCloser
+	layout resultLayout
+}
+
+func newMarkdownResultWriter(w io.WriteCloser, layout resultLayout, _ writerOptions) ResultWriter {
+	return &markdownResultWriter{w: w, layout: layout}
+}
+
+func (m *markdownResultWriter) WriteHeader() error {
+	separator := make([]string, len(m.layout))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	_, err := io.WriteString(m.w, markdownLine(m.layout.names())+markdownLine(separator))
+	return err
+}
+
+func (m *markdownResultWriter) WriteRow(result *PodResult) error {
+	_, err := io.WriteString(m.w, markdownLine(m.layout.row(result)))
+	return err
+}
+
+func (m *markdownResultWriter) Close() error {
+	return m.w.Close()
+}
+
+func markdownLine(cells []string) string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = strings.ReplaceAll(cell, "|", `\|`)
+	}
+	return "| " + strings.Join(escaped, " | ") + " |\n"
+}
+
+// prettyResultWriter writes an aligned, human-readable table. Nothing is
+// written until Close, and Close doesn't close w, which is usually stderr.
+type prettyResultWriter struct {
+	tw     *tabwriter.Writer
+	layout resultLayout
+}
+
+func newPrettyResultWriter(w io.Writer, layout resultLayout) ResultWriter {
+	return &prettyResultWriter{tw: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0), layout: layout}
+}
+
+func (p *prettyResultWriter) WriteHeader() error {
+	_, err := fmt.Fprintln(p.tw, strings.Join(p.layout.names(), "\t"))
+	return err
+}
+
+func (p *prettyResultWriter) WriteRow(result *PodResult) error {
+	_, err := fmt.Fprintln(p.tw, strings.Join(p.layout.row(result), "\t"))
+	return err
+}
+
+func (p *prettyResultWriter) Close() error {
+	return p.tw.Flush()
+}
+
+// templateResultWriter executes a user-supplied text/template once per
+// result, with the PodResult as data. Each row ends with a newline.
+type templateResultWriter struct {
+	w    io.WriteCloser
+	tmpl *template.Template
+}
+
+func newTemplateResultWriter(w io.WriteCloser, tmpl *template.Template) ResultWriter {
+	return &templateResultWriter{w: w, tmpl: tmpl}
+}
+
+// parseResultTemplate parses a -template value, reading it from a file when
+// it starts with "@". The cpu and memory functions format usage the same way
+// as the other writers, e.g. {{cpu .AvgCPUMilli}}.
+func parseResultTemplate(value string, quantities quantityFormat) (*template.Template, error) {
+	text := value
+	if strings.HasPrefix(value, "@") {
+		data, err := os.ReadFile(strings.TrimPrefix(value, "@"))
+		if err != nil {
+			return nil, fmt.Errorf("reading template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("result").Funcs(template.FuncMap{
+		"cpu":    quantities.cpu,
+		"memory": quantities.memory,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	return tmpl, nil
+}
+
+func (t *templateResultWriter) WriteHeader() error {
+	return nil
+}
+
+func (t *templateResultWriter) WriteRow(result *PodResult) error {
+	var b bytes.Buffer
+	if err := t.tmpl.Execute(&b, result); err != nil {
+		return fmt.Errorf("executing template: %w", err)
+	}
+	if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteString("\n")
+	}
+	_, err := t.w.Write(b.Bytes())
+	return err
+}
+
+func (t *templateResultWriter) Close() error {
+	return t.w.Close()
+}
+
+// namespaceSplitWriter writes each namespace's rows through a writer of its
+// own, for -split-by-namespace. The writer comes from create on the
+// namespace's first row, with its header written then, so only namespaces
+// that have rows get a file.
+type namespaceSplitWriter struct {
+	create  func(namespace string) (ResultWriter, error)
+	writers map[string]ResultWriter
+}
+
+func newNamespaceSplitWriter(create func(namespace string) (ResultWriter, error)) *namespaceSplitWriter {
+	return &namespaceSplitWriter{create: create, writers: make(map[string]ResultWriter)}
+}
+
+func (s *namespaceSplitWriter) WriteHeader() error {
+	return nil
+}
+
+func (s *namespaceSplitWriter) WriteRow(result *PodResult) error {
+	w, ok := s.writers[result.Namespace]
+	if !ok {
+		var err error
+		if w, err = s.create(result.Namespace); err != nil {
+			return err
+		}
+		if err := w.WriteHeader(); err != nil {
+			w.Close()
+			return err
+		}
+		s.writers[result.Namespace] = w
+	}
+	return w.WriteRow(result)
+}
+
+func (s *namespaceSplitWriter) Close() error {
+	var err error
+	for _, w := range s.writers {
+		if wErr := w.Close(); err == nil {
+			err = wErr
+		}
+	}
+	return err
+}
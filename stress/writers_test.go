@@ -0,0 +1,54 @@
+package stress
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceSplitCSVHeader(t *testing.T) {
+	dir := t.TempDir()
+	layout := resultLayout{
+		{"namespace", func(r *PodResult) string { return r.Namespace }},
+		{"pod", func(r *PodResult) string { return r.Pod }},
+	}
+	for _, alwaysQuote := range []bool{false, true} {
+		opts := writerOptions{csvAlwaysQuote: alwaysQuote, csvHeader: true}
+		split := newNamespaceSplitWriter(func(namespace string) (ResultWriter, error) {
+			file, err := os.Create(namespaceOutputPath(dir, namespace, "csv"))
+			if err != nil {
+				return nil, err
+			}
+			return newCSVResultWriter(file, layout, opts), nil
+		})
+		for _, result := range []*PodResult{{Namespace: "default", Pod: "web-1"}, {Namespace: "batch", Pod: "job-1"}, {Namespace: "default", Pod: "web-2"}} {
+			if err := split.WriteRow(result); err != nil {
+				t.Fatalf("WriteRow: %v", err)
+			}
+		}
+		if err := split.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		want := map[string][][]string{
+			"default": {{"namespace", "pod"}, {"default", "web-1"}, {"default", "web-2"}},
+			"batch":   {{"namespace", "pod"}, {"batch", "job-1"}},
+		}
+		for namespace, wantRecords := range want {
+			file, err := os.Open(filepath.Join(dir, namespace+".csv"))
+			if err != nil {
+				t.Fatalf("opening split file: %v", err)
+			}
+			records, err := csv.NewReader(file).ReadAll()
+			file.Close()
+			if err != nil {
+				t.Fatalf("reading %s.csv: %v", namespace, err)
+			}
+			if !reflect.DeepEqual(records, wantRecords) {
+				t.Errorf("always quote %v: %s.csv = %q, want %q", alwaysQuote, namespace, records, wantRecords)
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+// Build information, injected at build time with:
+//
+//	go build -ldflags "-X main.version=v1.0.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var version, commit, date string
+
+// versionString formats the build information for the -version flag.
+func versionString() string {
+	orUnknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	v := version
+	if v == "" {
+		v = "dev"
+	}
+	return fmt.Sprintf("stresstest %s (commit %s, built %s)", v, orUnknown(commit), orUnknown(date))
+}